@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Threshold limits are given per minute and scaled to the window length, 0 disables a limit
+type Threshold struct {
+	Bytes   uint64 `json:"bytes"`
+	Packets uint64 `json:"packets"`
+	Flows   uint64 `json:"flows"`
+}
+
+// Exceeded reports whether any limit is crossed by item during window
+func (t Threshold) Exceeded(item sortItem, window Window) bool {
+	scale := window.End.Sub(window.Start).Minutes()
+	if scale <= 0 {
+		return false
+	}
+	over := func(value, limit uint64) bool {
+		return limit > 0 && float64(value) > float64(limit)*scale
+	}
+	return over(item.Bytes, t.Bytes) || over(item.Packets, t.Packets) || over(item.Flows, t.Flows)
+}
+
+type AlertRule struct {
+	Name string `json:"name"`
+	Threshold
+
+	// Aggregation restricts the rule to one aggregation, empty for client aggregations
+	Aggregation string `json:"aggregation"`
+
+	// Increase fires when the byte rate exceeds this many times the prefix's own baseline
+	Increase float64 `json:"increase"`
+
+	Command  string   `json:"command"`
+	Webhook  string   `json:"webhook"`
+	Cooldown Duration `json:"cooldown"`
+}
+
+type Alert struct {
+	Rule string `json:"rule"`
+	Tenant
+	Aggregation string       `json:"aggregation"`
+	Prefix      netip.Prefix `json:"prefix"`
+	Baseline    float64      `json:"baseline"` // bytes per minute
+	Start       time.Time    `json:"start"`
+	End         time.Time    `json:"end"`
+	AcctData
+}
+
+const (
+	// baselineAlpha is the weight of the latest window in the moving average
+	baselineAlpha = 0.1
+	// baselineSamples is the number of windows needed before the baseline is trusted
+	baselineSamples = 5
+)
+
+type baseline struct {
+	rate    float64 // bytes per minute
+	samples int
+}
+
+type alertKey struct {
+	rule string
+	acctKey
+}
+
+// Alerter evaluates alert rules against each closed window
+type Alerter struct {
+	Rules []AlertRule
+
+	// DryRun only logs alerts without running commands or calling webhooks, e.g. when replaying
+	DryRun bool
+
+	baselines map[acctKey]*baseline
+	fired     map[alertKey]time.Time
+	client    http.Client
+}
+
+func NewAlerter(rules []AlertRule) *Alerter {
+	return &Alerter{
+		Rules:     rules,
+		baselines: make(map[acctKey]*baseline),
+		fired:     make(map[alertKey]time.Time),
+		client:    http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *Alerter) matches(rule AlertRule, item sortItem, window Window, rate float64) bool {
+	if !aggregationMatches(rule.Aggregation, item.Aggregation) {
+		return false
+	}
+	if rule.Threshold.Exceeded(item, window) {
+		return true
+	}
+	if rule.Increase > 0 {
+		b := a.baselines[item.key()]
+		return b != nil && b.samples >= baselineSamples && rate > rule.Increase*b.rate
+	}
+	return false
+}
+
+func (a *Alerter) Evaluate(window Window) {
+	minutes := window.End.Sub(window.Start).Minutes()
+	if minutes <= 0 {
+		return
+	}
+	rates := make(map[acctKey]float64, len(window.Items))
+	for _, item := range window.Items {
+		itemKey := item.key()
+		rate := float64(item.Bytes) / minutes
+		rates[itemKey] = rate
+		for _, rule := range a.Rules {
+			if !a.matches(rule, item, window, rate) {
+				continue
+			}
+			key := alertKey{rule.Name, itemKey}
+			if last, ok := a.fired[key]; ok && window.End.Sub(last) < time.Duration(rule.Cooldown) {
+				continue
+			}
+			a.fired[key] = window.End
+			alert := Alert{
+				Rule:        rule.Name,
+				Tenant:      item.Tenant,
+				Aggregation: item.Aggregation,
+				Prefix:      item.Addr,
+				Start:       window.Start,
+				End:         window.End,
+				AcctData:    item.AcctData,
+			}
+			if b := a.baselines[itemKey]; b != nil {
+				alert.Baseline = b.rate
+			}
+			if a.DryRun {
+				log.Printf("Would alert %s: %s %d bytes %d packets %d flows\n",
+					alert.Rule, alert.Prefix, alert.Bytes, alert.Packets, alert.Flows)
+				continue
+			}
+			log.Printf("Alert %s: %s %d bytes %d packets %d flows\n",
+				alert.Rule, alert.Prefix, alert.Bytes, alert.Packets, alert.Flows)
+			go a.fire(rule, alert)
+		}
+	}
+	a.updateBaselines(rates)
+	for key, last := range a.fired {
+		if window.End.Sub(last) > 24*time.Hour {
+			delete(a.fired, key)
+		}
+	}
+}
+
+// updateBaselines folds the latest window into every baseline, prefixes absent from it count as zero
+func (a *Alerter) updateBaselines(rates map[acctKey]float64) {
+	for key, rate := range rates {
+		if a.baselines[key] == nil {
+			a.baselines[key] = &baseline{rate: rate}
+		}
+	}
+	for key, b := range a.baselines {
+		b.rate = (1-baselineAlpha)*b.rate + baselineAlpha*rates[key]
+		b.samples++
+		if b.rate < 1 {
+			delete(a.baselines, key)
+		}
+	}
+}
+
+func (a *Alerter) fire(rule AlertRule, alert Alert) {
+	if rule.Command != "" {
+		cmd := exec.Command("/bin/sh", "-c", rule.Command)
+		cmd.Env = append(os.Environ(),
+			"CTMON_RULE="+alert.Rule,
+			"CTMON_NAMESPACE="+alert.Namespace,
+			fmt.Sprintf("CTMON_ZONE=%d", alert.Zone),
+			"CTMON_AGGREGATION="+alert.Aggregation,
+			"CTMON_PREFIX="+alert.Prefix.String(),
+			fmt.Sprintf("CTMON_BYTES=%d", alert.Bytes),
+			fmt.Sprintf("CTMON_PACKETS=%d", alert.Packets),
+			fmt.Sprintf("CTMON_FLOWS=%d", alert.Flows),
+			fmt.Sprintf("CTMON_BASELINE=%.0f", alert.Baseline),
+			"CTMON_START="+alert.Start.Format(time.RFC3339),
+			"CTMON_END="+alert.End.Format(time.RFC3339),
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("Alert command failed: %s: %s\n", err, output)
+		}
+	}
+	if rule.Webhook != "" {
+		b, _ := json.Marshal(alert)
+		resp, err := a.client.Post(rule.Webhook, "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Printf("Alert webhook failed: %s\n", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Alert webhook failed: %s\n", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+)
+
+// APIServer serves the current and recent windows of a Recorder as JSON
+type APIServer struct {
+	Recorder  *Recorder
+	Blocklist *Blocklist // optional
+	Enricher  *Enricher  // optional
+}
+
+type apiQuery struct {
+	top     int
+	windows int
+	filter  netip.Prefix
+	sortKey string
+	agg     string
+	tenant  string
+
+	enricher *Enricher
+}
+
+type apiResponse struct {
+	Current Window   `json:"current"`
+	History []Window `json:"history"`
+}
+
+func parseAPIQuery(req *http.Request) (q apiQuery, err error) {
+	query := req.URL.Query()
+	q.windows = -1
+	if s := query.Get("top"); s != "" {
+		if q.top, err = strconv.Atoi(s); err != nil {
+			return
+		}
+	}
+	if s := query.Get("windows"); s != "" {
+		if q.windows, err = strconv.Atoi(s); err != nil {
+			return
+		}
+	}
+	if q.filter, err = parseFilter(query.Get("prefix")); err != nil {
+		return
+	}
+	q.agg = query.Get("aggregation")
+	q.tenant = query.Get("tenant")
+	q.sortKey = query.Get("sort")
+	if q.sortKey == "" {
+		q.sortKey = "bytes"
+	}
+	return
+}
+
+func (q apiQuery) apply(window Window) (Window, error) {
+	items := filterItems(slices.Clone(window.Items), q.filter)
+	if q.agg != "" {
+		items = slices.DeleteFunc(items, func(item sortItem) bool {
+			return item.Aggregation != q.agg
+		})
+	}
+	if q.tenant != "" {
+		items = filterTenant(items, q.tenant)
+	}
+	if err := sortItemsBy(items, q.sortKey); err != nil {
+		return window, err
+	}
+	if q.top > 0 && len(items) > q.top {
+		items = items[:q.top]
+	}
+	if q.enricher != nil {
+		q.enricher.Enrich(items, false)
+	}
+	window.Items = items
+	return window, nil
+}
+
+func (s *APIServer) HandleWindows(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed\n", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseAPIQuery(req)
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error()+"\n", http.StatusBadRequest)
+		return
+	}
+	q.enricher = s.Enricher
+
+	current, history := s.Recorder.Windows()
+	if q.windows >= 0 && len(history) > q.windows {
+		history = history[len(history)-q.windows:]
+	}
+	var resp apiResponse
+	if resp.Current, err = q.apply(current); err != nil {
+		http.Error(w, err.Error()+"\n", http.StatusBadRequest)
+		return
+	}
+	resp.History = make([]Window, len(history))
+	for i := range history {
+		resp.History[i], _ = q.apply(history[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error writing response: %s\n", err)
+	}
+}
+
+func (s *APIServer) HandleBans(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed\n", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Blocklist.Bans()); err != nil {
+		log.Printf("Error writing response: %s\n", err)
+	}
+}
+
+func (s *APIServer) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/windows", s.HandleWindows)
+	if s.Blocklist != nil {
+		mux.HandleFunc("/bans", s.HandleBans)
+	}
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+type BlocklistConfig struct {
+	Threshold
+
+	// Aggregation restricts banning to one aggregation, empty for client aggregations
+	Aggregation string `json:"aggregation"`
+
+	// Family and Table locate the nftables sets, which must be created beforehand in every watched namespace with
+	// "flags interval, timeout" and types ipv4_addr and ipv6_addr respectively
+	Family string `json:"family"`
+	Table  string `json:"table"`
+	Set    string `json:"set"`
+	Set6   string `json:"set6"`
+
+	Timeout   Duration       `json:"timeout"`
+	Allowlist []netip.Prefix `json:"allowlist"`
+	DryRun    bool           `json:"dry_run"`
+}
+
+type Ban struct {
+	Namespace string       `json:"namespace,omitempty"`
+	Prefix    netip.Prefix `json:"prefix"`
+	Expires   time.Time    `json:"expires"`
+	DryRun    bool         `json:"dry_run,omitempty"`
+	AcctData
+}
+
+// banKey identifies a ban. The sets live in each watched namespace and are shared by its zones.
+type banKey struct {
+	namespace string
+	prefix    netip.Prefix
+}
+
+// Blocklist adds prefixes crossing a threshold to the nftables sets of the namespace
+// they were seen in and tracks active bans
+type Blocklist struct {
+	config  BlocklistConfig
+	sources map[string]Source // by name
+
+	mu   sync.Mutex
+	bans map[banKey]Ban
+}
+
+func NewBlocklist(config BlocklistConfig, sources []Source) *Blocklist {
+	if config.Family == "" {
+		config.Family = "inet"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = Duration(time.Hour)
+	}
+	b := &Blocklist{config: config, sources: make(map[string]Source), bans: make(map[banKey]Ban)}
+	for _, source := range sources {
+		b.sources[source.Name] = source
+	}
+	return b
+}
+
+func (b *Blocklist) allowed(prefix netip.Prefix) bool {
+	for _, allow := range b.config.Allowlist {
+		if allow.Overlaps(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Blocklist) ban(namespace string, prefix netip.Prefix, timeout time.Duration) error {
+	source, ok := b.sources[namespace]
+	if !ok {
+		return fmt.Errorf("cannot ban %s in unknown namespace %s", prefix, namespace)
+	}
+	set := b.config.Set
+	if prefix.Addr().Is6() {
+		set = b.config.Set6
+	}
+	if set == "" {
+		return fmt.Errorf("no nftables set configured for %s", prefix)
+	}
+	element := fmt.Sprintf("{ %s timeout %ds }", prefix, int64(timeout.Seconds()))
+	cmd := source.Exec("nft", "add", "element", b.config.Family, b.config.Table, set, element)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft: %w: %s", err, output)
+	}
+	return nil
+}
+
+// Evaluate bans every prefix of window crossing the threshold that is not banned yet
+func (b *Blocklist) Evaluate(window Window) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := window.End
+	for key, ban := range b.bans {
+		if now.After(ban.Expires) {
+			delete(b.bans, key)
+		}
+	}
+
+	timeout := time.Duration(b.config.Timeout)
+	for _, item := range window.Items {
+		if !aggregationMatches(b.config.Aggregation, item.Aggregation) {
+			continue
+		}
+		if !b.config.Threshold.Exceeded(item, window) {
+			continue
+		}
+		key := banKey{item.Namespace, item.Addr}
+		if _, ok := b.bans[key]; ok || b.allowed(item.Addr) {
+			continue
+		}
+		ban := Ban{Namespace: item.Namespace, Prefix: item.Addr, Expires: now.Add(timeout), DryRun: b.config.DryRun, AcctData: item.AcctData}
+		name := item.Addr.String()
+		if item.Namespace != "" {
+			name += " in " + item.Namespace
+		}
+		if b.config.DryRun {
+			log.Printf("Would ban %s for %s: %d bytes %d packets %d flows\n",
+				name, timeout, item.Bytes, item.Packets, item.Flows)
+		} else {
+			if err := b.ban(item.Namespace, item.Addr, timeout); err != nil {
+				log.Println(err)
+				continue
+			}
+			log.Printf("Banned %s for %s: %d bytes %d packets %d flows\n",
+				name, timeout, item.Bytes, item.Packets, item.Flows)
+		}
+		b.bans[key] = ban
+	}
+}
+
+// Bans returns the active bans, soonest to expire first
+func (b *Blocklist) Bans() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	bans := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if ban.Expires.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return a.Expires.Compare(b.Expires)
+	})
+	return bans
+}
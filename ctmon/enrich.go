@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type EnrichConfig struct {
+	// Databases are ip2asn TSV files or MaxMind .mmdb files (ASN, Country or City),
+	// the first one to provide a value wins
+	Databases []string `json:"databases"`
+
+	// ReverseDNS resolves a sample address of the top N prefixes of each window
+	ReverseDNS int `json:"reverse_dns"`
+}
+
+type NetInfo struct {
+	ASN     uint32 `json:"asn,omitempty"`
+	ASName  string `json:"as_name,omitempty"`
+	Country string `json:"country,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+func (info *NetInfo) String() string {
+	if info == nil {
+		return ""
+	}
+	var parts []string
+	if info.ASN != 0 {
+		parts = append(parts, fmt.Sprintf("AS%d", info.ASN))
+	}
+	if info.Country != "" {
+		parts = append(parts, info.Country)
+	}
+	if info.ASName != "" {
+		parts = append(parts, info.ASName)
+	}
+	if info.Name != "" {
+		parts = append(parts, "("+info.Name+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// merge fills empty fields of info from other
+func (info *NetInfo) merge(other NetInfo) {
+	if info.ASN == 0 {
+		info.ASN, info.ASName = other.ASN, other.ASName
+	}
+	if info.Country == "" {
+		info.Country = other.Country
+	}
+}
+
+type netDB interface {
+	Lookup(addr netip.Addr) NetInfo
+}
+
+type asnRange struct {
+	start, end netip.Addr
+	info       NetInfo
+}
+
+// ip2asnDB is a sorted list of ranges from an ip2asn TSV file
+// (range_start, range_end, AS_number, country_code, AS_description)
+type ip2asnDB []asnRange
+
+func loadIP2ASN(filename string) (ip2asnDB, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var db ip2asnDB
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || asn == 0 {
+			continue
+		}
+		start, err1 := netip.ParseAddr(fields[0])
+		end, err2 := netip.ParseAddr(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		country := fields[3]
+		if country == "None" {
+			country = ""
+		}
+		db = append(db, asnRange{start.Unmap(), end.Unmap(), NetInfo{ASN: uint32(asn), ASName: fields[4], Country: country}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(db, func(a, b asnRange) int {
+		return a.start.Compare(b.start)
+	})
+	return db, nil
+}
+
+func (db ip2asnDB) Lookup(addr netip.Addr) NetInfo {
+	// Find the last range starting at or before addr
+	i, found := slices.BinarySearchFunc(db, addr, func(r asnRange, addr netip.Addr) int {
+		return r.start.Compare(addr)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || db[i].end.Compare(addr) < 0 || db[i].start.Is4() != addr.Is4() {
+		return NetInfo{}
+	}
+	return db[i].info
+}
+
+type mmdb struct {
+	reader *maxminddb.Reader
+}
+
+func (db mmdb) Lookup(addr netip.Addr) NetInfo {
+	var record struct {
+		ASN     uint32 `maxminddb:"autonomous_system_number"`
+		ASName  string `maxminddb:"autonomous_system_organization"`
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := db.reader.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+		return NetInfo{}
+	}
+	return NetInfo{ASN: record.ASN, ASName: record.ASName, Country: record.Country.ISOCode}
+}
+
+type rdnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+const (
+	rdnsTTL     = time.Hour
+	rdnsTimeout = 2 * time.Second
+)
+
+// Enricher annotates items with network information and cached reverse DNS names
+type Enricher struct {
+	dbs        []netDB
+	reverseDNS int
+
+	mu    sync.Mutex
+	names map[netip.Addr]rdnsEntry
+}
+
+func NewEnricher(config EnrichConfig) (*Enricher, error) {
+	e := &Enricher{reverseDNS: config.ReverseDNS, names: make(map[netip.Addr]rdnsEntry)}
+	for _, filename := range config.Databases {
+		if strings.HasSuffix(filename, ".mmdb") {
+			reader, err := maxminddb.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			e.dbs = append(e.dbs, mmdb{reader})
+			continue
+		}
+		db, err := loadIP2ASN(filename)
+		if err != nil {
+			return nil, err
+		}
+		e.dbs = append(e.dbs, db)
+	}
+	return e, nil
+}
+
+func (e *Enricher) cachedName(addr netip.Addr, now time.Time) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry, ok := e.names[addr]
+	if !ok || now.After(entry.expires) {
+		return "", false
+	}
+	return entry.name, true
+}
+
+func (e *Enricher) resolve(addr netip.Addr) {
+	ctx, cancel := context.WithTimeout(context.Background(), rdnsTimeout)
+	defer cancel()
+	var name string
+	if names, err := net.DefaultResolver.LookupAddr(ctx, addr.String()); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	e.mu.Lock()
+	e.names[addr] = rdnsEntry{name, time.Now().Add(rdnsTTL)}
+	e.mu.Unlock()
+}
+
+// Enrich sets Info of every item. If resolve is true, missing reverse DNS names of
+// the top entries are looked up first, otherwise only cached names are used.
+func (e *Enricher) Enrich(items []sortItem, resolve bool) {
+	now := time.Now()
+	if resolve {
+		var wg sync.WaitGroup
+		for _, item := range items[:min(e.reverseDNS, len(items))] {
+			if _, ok := e.cachedName(item.Sample, now); ok || !item.Sample.IsValid() {
+				continue
+			}
+			wg.Add(1)
+			go func(addr netip.Addr) {
+				defer wg.Done()
+				e.resolve(addr)
+			}(item.Sample)
+		}
+		wg.Wait()
+	}
+	for i := range items {
+		info := new(NetInfo)
+		for _, db := range e.dbs {
+			info.merge(db.Lookup(items[i].Addr.Addr()))
+		}
+		if i < e.reverseDNS {
+			info.Name, _ = e.cachedName(items[i].Sample, now)
+		}
+		if *info != (NetInfo{}) {
+			items[i].Info = info
+		}
+	}
+	e.mu.Lock()
+	for addr, entry := range e.names {
+		if now.After(entry.expires) {
+			delete(e.names, addr)
+		}
+	}
+	e.mu.Unlock()
+}
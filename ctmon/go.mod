@@ -1,3 +1,21 @@
 module ctmon
 
 go 1.22
+
+require (
+	github.com/influxdata/influxdb-client-go/v2 v2.12.3
+	github.com/klauspost/compress v1.18.0
+	github.com/oschwald/maxminddb-golang v1.13.1
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/term v0.21.0
+)
+
+require (
+	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
+	github.com/deepmap/oapi-codegen v1.12.4 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/influxdata/line-protocol v0.0.0-20210922203350-b1ad95c89adf // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+type InfluxDBConfig struct {
+	Host     string `json:"host"`
+	Token    string `json:"token"`
+	Database string `json:"database"`
+
+	// Top limits the number of prefixes written per window, aggregation and tenant, 0 for unlimited
+	Top int `json:"top"`
+}
+
+type InfluxSink struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPI
+	hostname string
+	top      int
+}
+
+func NewInfluxSink(config InfluxDBConfig) *InfluxSink {
+	hostname, _ := os.Hostname()
+	client := influxdb2.NewClient(config.Host, config.Token)
+	writeAPI := client.WriteAPI("", config.Database)
+	go func() {
+		for err := range writeAPI.Errors() {
+			log.Println("InfluxDB write error:", err)
+		}
+	}()
+	return &InfluxSink{
+		client:   client,
+		writeAPI: writeAPI,
+		hostname: hostname,
+		top:      config.Top,
+	}
+}
+
+// Write sends one point per prefix at the start of the window. Items are sorted by bytes,
+// so the top prefixes of each aggregation and tenant are the first ones seen.
+func (s *InfluxSink) Write(window Window) {
+	type group struct {
+		Tenant
+		aggregation string
+	}
+	written := make(map[group]int)
+	for _, item := range window.Items {
+		if s.top > 0 {
+			g := group{item.Tenant, item.Aggregation}
+			if written[g] >= s.top {
+				continue
+			}
+			written[g]++
+		}
+		p := influxdb2.NewPointWithMeasurement("conntrack").
+			AddTag("host", s.hostname).
+			AddTag("prefix", item.Addr.String()).
+			AddTag("aggregation", item.Aggregation).
+			AddField("packets", item.Packets).
+			AddField("bytes", item.Bytes).
+			AddField("orig_packets", item.OrigPackets).
+			AddField("orig_bytes", item.OrigBytes).
+			AddField("reply_packets", item.ReplyPackets).
+			AddField("reply_bytes", item.ReplyBytes).
+			AddField("flows", item.Flows).
+			AddField("duration", item.Duration).
+			SetTime(window.Start)
+		if item.Namespace != "" {
+			p.AddTag("namespace", item.Namespace)
+		}
+		if item.Zone != 0 {
+			p.AddTag("zone", strconv.FormatUint(uint64(item.Zone), 10))
+		}
+		s.writeAPI.WritePoint(p)
+	}
+}
+
+func (s *InfluxSink) Close() {
+	s.writeAPI.Flush()
+	s.client.Close()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+type flowKey struct {
+	ID           uint32
+	Zone         uint16
+	Src, Dst     netip.Addr
+	Sport, Dport uint16
+}
+
+type flowState struct {
+	CTLine
+	gen       uint64
+	destroyed bool
+}
+
+// FlowTracker remembers the counters already accounted for each live flow,
+// so that periodic snapshots of the conntrack table only record what changed.
+// Every namespace has its own conntrack table and thus its own FlowTracker.
+type FlowTracker struct {
+	Source Source
+
+	mu    sync.Mutex
+	gen   uint64
+	flows map[flowKey]*flowState
+}
+
+func NewFlowTracker(source Source) *FlowTracker {
+	return &FlowTracker{Source: source, flows: make(map[flowKey]*flowState)}
+}
+
+func subCounters(cur, prev CTDirection) CTDirection {
+	if cur.Packets < prev.Packets || cur.Bytes < prev.Bytes {
+		return cur
+	}
+	cur.Packets -= prev.Packets
+	cur.Bytes -= prev.Bytes
+	return cur
+}
+
+// Update returns the counters accumulated by a flow since it was last accounted for.
+// ok is false if nothing should be recorded, and isNew is true the first time a flow is recorded.
+func (t *FlowTracker) Update(line CTLine, destroyed bool) (delta CTLine, isNew, ok bool) {
+	key := flowKey{line.ID, line.Zone, line.Orig.Src, line.Orig.Dst, line.Orig.Sport, line.Orig.Dport}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := t.flows[key]
+	if state != nil && state.destroyed {
+		// A snapshot taken before the flow ended may still list it
+		return line, false, false
+	}
+	if !ctFilter(line) {
+		if destroyed {
+			t.flows[key] = &flowState{gen: t.gen, destroyed: true}
+		}
+		return line, false, false
+	}
+
+	delta = line
+	if state == nil {
+		isNew = true
+		state = new(flowState)
+		t.flows[key] = state
+	} else {
+		delta.Orig = subCounters(line.Orig, state.Orig)
+		delta.Reply = subCounters(line.Reply, state.Reply)
+	}
+	state.CTLine = line
+	state.gen = t.gen
+	state.destroyed = destroyed
+	return delta, isNew, true
+}
+
+// beginSnapshot starts a new generation of flows
+func (t *FlowTracker) beginSnapshot() {
+	t.mu.Lock()
+	t.gen++
+	t.mu.Unlock()
+}
+
+// sweep forgets flows that were not seen in the latest snapshot
+func (t *FlowTracker) sweep() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, state := range t.flows {
+		if state.gen < t.gen {
+			delete(t.flows, key)
+		}
+	}
+}
+
+func (t *FlowTracker) snapshot(record func(CTLine, bool)) error {
+	cmd := t.Source.Command("-L", "-p", "tcp", "-o", "id")
+	reader, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	t.beginSnapshot()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line, err := ParseCTLine(scanner.Text())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		line.Namespace = t.Source.Name
+		if delta, isNew, ok := t.Update(line, false); ok {
+			record(delta, isNew)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	t.sweep()
+	return nil
+}
+
+// SnapshotLoop lists the conntrack table every interval and records counter deltas of live flows
+func (t *FlowTracker) SnapshotLoop(interval time.Duration, record func(CTLine, bool)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := t.snapshot(record); err != nil {
+			log.Printf("conntrack snapshot in namespace %s: %s\n", t.Source, err)
+		}
+		<-ticker.C
+	}
+}
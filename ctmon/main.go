@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -9,10 +12,12 @@ import (
 	"net/netip"
 	"os"
 	"os/exec"
+	"os/signal"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -24,89 +29,317 @@ type CTDirection struct {
 
 type CTLine struct {
 	Orig, Reply CTDirection
+	ID          uint32
+	Tenant
+
+	// Duration is the flow lifetime in seconds, only known at DESTROY with nf_conntrack_timestamp
+	Duration uint64
 }
 
 type AcctData struct {
-	Packets, Bytes uint64
+	// Packets and Bytes are the sums of both directions
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+
+	OrigPackets  uint64 `json:"orig_packets"`
+	OrigBytes    uint64 `json:"orig_bytes"`
+	ReplyPackets uint64 `json:"reply_packets"`
+	ReplyBytes   uint64 `json:"reply_bytes"`
+
+	Flows    uint64 `json:"flows"`
+	Duration uint64 `json:"duration"` // total flow lifetime in seconds
+
+	// Sample is the first address seen in the prefix
+	Sample netip.Addr `json:"sample"`
+}
+
+type Window struct {
+	Start time.Time  `json:"start"`
+	End   time.Time  `json:"end"`
+	Items []sortItem `json:"items"`
+}
+
+type acctKey struct {
+	Tenant
+	Aggregation string
+	Prefix      netip.Prefix
 }
 
 type Recorder struct {
-	mu   sync.Mutex
-	data map[netip.Prefix]AcctData
+	mu    sync.Mutex
+	data  map[acctKey]AcctData
+	start time.Time
+
+	// Aggregations to account every flow to, defaults to client /24 and /48
+	Aggregations []Aggregation
+
+	// History is the number of closed windows to keep in memory
+	History int
+	history []Window
+
+	// Tenants is set when watching other namespaces, tenants are also shown once a zone is seen
+	Tenants     bool
+	tenantsSeen bool
 }
 
 func (r *Recorder) Record(line CTLine) {
+	r.RecordDelta(line, true)
+}
+
+// RecordDelta accounts counters of a flow that may have been partially recorded before
+func (r *Recorder) RecordDelta(line CTLine, newFlow bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	src := line.Orig.Src.Unmap()
-	key, _ := src.Prefix(24)
-	if src.Is6() {
-		key, _ = src.Prefix(48)
+	if line.Tenant != (Tenant{}) {
+		r.tenantsSeen = true
 	}
+	aggs := r.Aggregations
+	if len(aggs) == 0 {
+		aggs = defaultAggregations
+	}
+	for _, agg := range aggs {
+		addr, prefix := agg.Key(line)
+		r.add(acctKey{line.Tenant, agg.Name, prefix}, addr, line, newFlow)
+	}
+}
+
+func (r *Recorder) add(key acctKey, addr netip.Addr, line CTLine, newFlow bool) {
 	data := r.data[key]
-	data.Packets += line.Orig.Packets + line.Reply.Packets
-	data.Bytes += line.Orig.Bytes + line.Reply.Bytes
+	if !data.Sample.IsValid() {
+		data.Sample = addr
+	}
+	data.OrigPackets += line.Orig.Packets
+	data.OrigBytes += line.Orig.Bytes
+	data.ReplyPackets += line.Reply.Packets
+	data.ReplyBytes += line.Reply.Bytes
+	data.Packets = data.OrigPackets + data.ReplyPackets
+	data.Bytes = data.OrigBytes + data.ReplyBytes
+	data.Duration += line.Duration
+	if newFlow {
+		data.Flows++
+	}
 	r.data[key] = data
 }
 
-func (r *Recorder) reset() {
-	r.data = make(map[netip.Prefix]AcctData)
+func (r *Recorder) reset(start time.Time) {
+	r.data = make(map[acctKey]AcctData)
+	r.start = start
 }
 
 func (r *Recorder) Reset() {
+	r.ResetAt(time.Now())
+}
+
+// ResetAt discards the current window and starts a new one at start
+func (r *Recorder) ResetAt(start time.Time) {
 	r.mu.Lock()
-	r.reset()
+	r.reset(start)
 	r.mu.Unlock()
 }
 
 type sortItem struct {
-	Addr netip.Prefix
+	Tenant
+	Aggregation string       `json:"aggregation"`
+	Addr        netip.Prefix `json:"prefix"`
 	AcctData
+	Info *NetInfo `json:"info,omitempty"`
+}
+
+func (item sortItem) key() acctKey {
+	return acctKey{item.Tenant, item.Aggregation, item.Addr}
+}
+
+// ShowTenants reports whether outputs have a tenant column. It only changes once, when the first
+// zone is seen, so that the layout of the output file stays the same from window to window.
+func (r *Recorder) ShowTenants() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Tenants || r.tenantsSeen
+}
+
+// hasTenants reports whether any item was seen outside ctmon's own namespace or in a zone
+func hasTenants(items []sortItem) bool {
+	return slices.ContainsFunc(items, func(item sortItem) bool {
+		return item.Tenant != Tenant{}
+	})
 }
 
 func (r *Recorder) collect() []sortItem {
 	items := make([]sortItem, 0, len(r.data))
 	for k, v := range r.data {
-		items = append(items, sortItem{k, v})
+		items = append(items, sortItem{Tenant: k.Tenant, Aggregation: k.Aggregation, Addr: k.Prefix, AcctData: v})
 	}
 	return items
 }
 
-func dumpItems(w io.Writer, items []sortItem) {
-	now := time.Now()
+func sortItems(items []sortItem) {
 	slices.SortFunc(items, func(a, b sortItem) int {
 		// More bytes = sort first
-		if a.Bytes != b.Bytes {
-			return int(b.Bytes - a.Bytes)
+		if c := cmp.Compare(b.Bytes, a.Bytes); c != 0 {
+			return c
 		}
-		if a.Packets != b.Packets {
-			return int(b.Packets - a.Packets)
+		if c := cmp.Compare(b.Packets, a.Packets); c != 0 {
+			return c
 		}
 		// Then sort by address
-		return a.Addr.Addr().Compare(b.Addr.Addr())
+		if c := a.Addr.Addr().Compare(b.Addr.Addr()); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Aggregation, b.Aggregation); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Namespace, b.Namespace); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Zone, b.Zone)
 	})
-	buf := bufio.NewWriter(w)
-	defer buf.Flush()
-	fmt.Fprintf(buf, "Time: %s\n", now.Format(time.DateTime))
-	for _, item := range items {
-		fmt.Fprintf(buf, "  %20s %8d %12d\n", item.Addr.String(), item.Packets, item.Bytes)
+}
+
+type column struct {
+	width int
+	value func(AcctData) uint64
+}
+
+// columns are the AcctData fields that can be sorted by and written to the output file
+var columns = map[string]column{
+	"packets":       {8, func(d AcctData) uint64 { return d.Packets }},
+	"bytes":         {12, func(d AcctData) uint64 { return d.Bytes }},
+	"orig_packets":  {8, func(d AcctData) uint64 { return d.OrigPackets }},
+	"orig_bytes":    {12, func(d AcctData) uint64 { return d.OrigBytes }},
+	"reply_packets": {8, func(d AcctData) uint64 { return d.ReplyPackets }},
+	"reply_bytes":   {12, func(d AcctData) uint64 { return d.ReplyBytes }},
+	"flows":         {6, func(d AcctData) uint64 { return d.Flows }},
+	"duration":      {8, func(d AcctData) uint64 { return d.Duration }},
+}
+
+var defaultColumns = []string{"packets", "bytes"}
+
+func parseColumns(s string) ([]string, error) {
+	cols := strings.Split(s, ",")
+	for _, col := range cols {
+		if _, ok := columns[col]; !ok {
+			return nil, fmt.Errorf("unknown column: %s", col)
+		}
 	}
-	fmt.Fprintln(buf)
+	return cols, nil
 }
 
-func (r *Recorder) Dump(w io.Writer) {
+// sortItemsBy sorts items by a column in descending order, keeping the order of ties
+func sortItemsBy(items []sortItem, key string) error {
+	col, ok := columns[key]
+	if !ok {
+		return fmt.Errorf("unknown sort key: %s", key)
+	}
+	slices.SortStableFunc(items, func(a, b sortItem) int {
+		return cmp.Compare(col.value(b.AcctData), col.value(a.AcctData))
+	})
+	return nil
+}
+
+// filterItems keeps items that overlap with prefix, or all items if prefix is invalid
+func filterItems(items []sortItem, prefix netip.Prefix) []sortItem {
+	if !prefix.IsValid() {
+		return items
+	}
+	return slices.DeleteFunc(items, func(item sortItem) bool {
+		return !prefix.Overlaps(item.Addr)
+	})
+}
+
+// filterTenant keeps items of one tenant given as formatted by Tenant.String, or "-" for the zero value
+func filterTenant(items []sortItem, tenant string) []sortItem {
+	if tenant == "-" {
+		tenant = ""
+	}
+	return slices.DeleteFunc(items, func(item sortItem) bool {
+		return item.Tenant.String() != tenant
+	})
+}
+
+// parseFilter accepts either a prefix or a single address
+func parseFilter(s string) (netip.Prefix, error) {
+	if s == "" {
+		return netip.Prefix{}, nil
+	}
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// dumpWindow writes a window in a single Write so that a rotating output never splits it.
+// The aggregation and tenant columns are only written with showAgg and showTenant,
+// keeping the default format unchanged.
+func dumpWindow(w io.Writer, window Window, cols []string, showAgg, showTenant bool) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Time: %s\n", window.End.Format(time.DateTime))
+	for _, item := range window.Items {
+		if showTenant {
+			fmt.Fprintf(&buf, "  %-16s", item.Tenant)
+		}
+		if showAgg {
+			fmt.Fprintf(&buf, "  %-12s", item.Aggregation)
+		}
+		fmt.Fprintf(&buf, "  %20s", item.Addr.String())
+		for _, name := range cols {
+			col := columns[name]
+			fmt.Fprintf(&buf, " %*d", col.width, col.value(item.AcctData))
+		}
+		if item.Info != nil {
+			fmt.Fprintf(&buf, "  %s", item.Info)
+		}
+		fmt.Fprintln(&buf)
+	}
+	fmt.Fprintln(&buf)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Snapshot returns the current window without resetting it
+func (r *Recorder) Snapshot() Window {
 	r.mu.Lock()
-	items := r.collect()
+	window := Window{Start: r.start, End: time.Now(), Items: r.collect()}
 	r.mu.Unlock()
-	dumpItems(w, items)
+	sortItems(window.Items)
+	return window
 }
 
-func (r *Recorder) DumpAndReset(w io.Writer) {
+func (r *Recorder) Dump(w io.Writer) {
+	dumpWindow(w, r.Snapshot(), defaultColumns, len(r.Aggregations) > 1, r.ShowTenants())
+}
+
+// Windows returns the current window and the closed windows kept in history, oldest first
+func (r *Recorder) Windows() (Window, []Window) {
+	current := r.Snapshot()
 	r.mu.Lock()
-	items := r.collect()
-	r.reset()
+	history := slices.Clone(r.history)
 	r.mu.Unlock()
-	dumpItems(w, items)
+	return current, history
+}
+
+// CollectAndReset closes the current window at end and starts the next one from there
+func (r *Recorder) CollectAndReset(end time.Time) Window {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	window := Window{Start: r.start, End: end, Items: r.collect()}
+	sortItems(window.Items)
+	r.reset(end)
+	if r.History > 0 {
+		r.history = append(r.history, window)
+		if len(r.history) > r.History {
+			r.history = slices.Delete(r.history, 0, len(r.history)-r.History)
+		}
+	}
+	return window
+}
+
+func (r *Recorder) DumpAndReset(w io.Writer) {
+	dumpWindow(w, r.CollectAndReset(time.Now()), defaultColumns, len(r.Aggregations) > 1, r.ShowTenants())
 }
 
 func ParseCTLine(s string) (CTLine, error) {
@@ -121,6 +354,12 @@ func ParseCTLine(s string) (CTLine, error) {
 			continue
 		}
 		switch parts[0] {
+		case "dst", "sport", "dport", "packets", "bytes":
+			if cur == nil {
+				return line, fmt.Errorf("unexpected %s before src", parts[0])
+			}
+		}
+		switch parts[0] {
 		case "src":
 			switch cur {
 			case nil:
@@ -161,11 +400,66 @@ func ParseCTLine(s string) (CTLine, error) {
 			if err != nil {
 				return line, err
 			}
+		case "delta-time":
+			line.Duration, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return line, err
+			}
+		case "zone":
+			value, err := strconv.ParseUint(parts[1], 10, 16)
+			if err != nil {
+				return line, err
+			}
+			line.Zone = uint16(value)
+		case "id":
+			value, err := strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				return line, err
+			}
+			line.ID = uint32(value)
 		}
 	}
 	return line, nil
 }
 
+type Config struct {
+	InfluxDB *InfluxDBConfig `json:"influxdb"`
+	Alerts   []AlertRule     `json:"alerts"`
+
+	Blocklist *BlocklistConfig `json:"blocklist"`
+	Enrich    *EnrichConfig    `json:"enrich"`
+}
+
+// Duration is a time.Duration written as a string like "10m" in config files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+func loadConfig(filename string) *Config {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	config := new(Config)
+	err = json.NewDecoder(f).Decode(config)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
 func sanityCheck() error {
 	b, err := os.ReadFile("/proc/sys/net/netfilter/nf_conntrack_acct")
 	if err != nil {
@@ -174,9 +468,19 @@ func sanityCheck() error {
 	if strings.TrimSpace(string(b)) == "0" {
 		return fmt.Errorf("nf_conntrack_acct is disabled")
 	}
+	if !kernelTimestamps() {
+		return fmt.Errorf("nf_conntrack_timestamp is disabled, flow durations are unavailable")
+	}
 	return nil
 }
 
+// kernelTimestamps reports whether nf_conntrack_timestamp is on, conntrack prints delta-time
+// with -o ktimestamp only then. Namespaces have their own setting, ctmon's is checked for all.
+func kernelTimestamps() bool {
+	b, err := os.ReadFile("/proc/sys/net/netfilter/nf_conntrack_timestamp")
+	return err == nil && strings.TrimSpace(string(b)) != "0"
+}
+
 // ctFilter determines whether a CTLine should be taken into accounting
 func ctFilter(line CTLine) bool {
 	if line.Orig.Packets+line.Reply.Packets < 10 {
@@ -193,55 +497,292 @@ func ctFilter(line CTLine) bool {
 	return true
 }
 
+// watch records the DESTROY events of one namespace and reports on done when they end
+func watch(source Source, r io.Reader, tracker *FlowTracker, recorder *Recorder, done chan<- Source) {
+	defer func() { done <- source }()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, err := ParseCTLine(scanner.Text())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		line.Namespace = source.Name
+		if tracker != nil {
+			if delta, isNew, ok := tracker.Update(line, true); ok {
+				recorder.RecordDelta(delta, isNew)
+			}
+			continue
+		}
+		if !ctFilter(line) {
+			continue
+		}
+		recorder.Record(line)
+	}
+}
+
+// alignWindow returns the start of the window containing t, aligned to the local wall clock
+// like :00, :05, :10 for 5m and midnight for 24h. Time.Truncate aligns to UTC instead, which
+// is off by the zone offset for days and in zones like +05:30 for hours.
+func alignWindow(t time.Time, windowLen time.Duration) time.Time {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	return midnight.Add(t.Sub(midnight).Truncate(windowLen))
+}
+
 func main() {
-	var outFilename string
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		queryMain(os.Args[2:])
+		return
+	}
+
+	var (
+		outFilename, configFile string
+		dbFilename              string
+		replayFilename          string
+		topN, historyN          int
+		sortKey, topFilter      string
+		columnList, aggList     string
+		netnsList               string
+		httpAddr                string
+		windowLen, liveInterval time.Duration
+		rotateSizeMB            int64
+		out                     RotateWriter
+	)
 	flag.StringVar(&outFilename, "o", "conntrack.log", "output file")
+	flag.StringVar(&configFile, "c", "", "config file (optional)")
+	flag.StringVar(&dbFilename, "db", "", "keep hourly, daily and monthly totals in this database `file` (see ctmon query -h)")
+	flag.IntVar(&topN, "top", 0, "show a live view of the top `N` prefixes")
+	flag.StringVar(&sortKey, "sort", "bytes", "sort key for the output file and the live view (any column)")
+	flag.StringVar(&aggList, "agg", "client",
+		"comma-separated aggregations `attribute[/bits4[/bits6]]`, attribute is client, public (after SNAT), peer or server (after DNAT)")
+	flag.StringVar(&columnList, "columns", strings.Join(defaultColumns, ","),
+		"comma-separated columns of the output file (packets, bytes, orig_packets, orig_bytes, reply_packets, reply_bytes, flows, duration)")
+	flag.StringVar(&topFilter, "filter", "", "only show prefixes within this `prefix` in the live view")
+	flag.StringVar(&netnsList, "netns", "self",
+		"comma-separated network namespaces to watch: self, names under /run/netns, PIDs or namespace file paths")
+	flag.StringVar(&httpAddr, "http", "", "serve the query API on this `address` (e.g. 127.0.0.1:8080)")
+	flag.IntVar(&historyN, "history", 10, "number of closed windows to keep for the query API")
+	flag.DurationVar(&windowLen, "w", time.Minute, "accounting window length")
+	flag.DurationVar(&liveInterval, "live", 0, "also account running flows by listing the conntrack table at this `interval`")
+	flag.StringVar(&replayFilename, "replay", "", "account saved `file` of conntrack -E -o timestamp,ktimestamp output instead of live events (- for stdin)")
+	flag.Int64Var(&rotateSizeMB, "rotate-size", 0, "rotate the output file when it exceeds `MB` megabytes")
+	flag.BoolVar(&out.Daily, "rotate-daily", false, "rotate the output file every day")
+	flag.StringVar(&out.Compress, "compress", "", "compress rotated files (gzip, zstd)")
+	flag.IntVar(&out.Keep, "keep", 0, "number of rotated files to keep, 0 for unlimited")
 	flag.Parse()
 
+	switch out.Compress {
+	case "", "gzip", "zstd":
+	default:
+		log.Fatalf("unknown compression: %s", out.Compress)
+	}
+	if windowLen <= 0 {
+		log.Fatal("window length must be positive")
+	}
+
+	filter, err := parseFilter(topFilter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cols, err := parseColumns(columnList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sources, err := ParseSources(netnsList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	aggs, err := ParseAggregations(aggList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := columns[sortKey]; !ok {
+		log.Fatalf("unknown sort key: %s", sortKey)
+	}
+
+	config := new(Config)
+	if configFile != "" {
+		config = loadConfig(configFile)
+	}
+
 	if _, ok := os.LookupEnv("JOURNAL_STREAM"); ok {
 		log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
 	}
 
-	outFile, err := os.OpenFile(outFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+	out.Filename = outFilename
+	out.MaxSize = rotateSizeMB << 20
+	if err := out.Open(); err != nil {
 		panic(err)
 	}
-	defer outFile.Close()
+	defer out.Close()
 
-	if err := sanityCheck(); err != nil {
-		log.Println("Warning: sanity check failed:", err)
+	var influx *InfluxSink
+	if config.InfluxDB != nil {
+		influx = NewInfluxSink(*config.InfluxDB)
+		defer influx.Close()
 	}
 
-	cmd := exec.Command("conntrack", "-E", "-e", "DESTROY", "-p", "tcp")
-	reader, err := cmd.StdoutPipe()
-	if err != nil {
-		panic(err)
+	var blocklist *Blocklist
+	if config.Blocklist != nil {
+		if replayFilename != "" && !config.Blocklist.DryRun {
+			log.Println("Replaying, blocklist runs in dry-run mode")
+			config.Blocklist.DryRun = true
+		}
+		blocklist = NewBlocklist(*config.Blocklist, sources)
 	}
-	if err = cmd.Start(); err != nil {
-		panic(err)
+
+	var enricher *Enricher
+	if config.Enrich != nil {
+		if enricher, err = NewEnricher(*config.Enrich); err != nil {
+			panic(err)
+		}
 	}
-	defer cmd.Wait()
 
-	var (
-		recorder Recorder
-		last     time.Time = time.Now()
-	)
+	var store *Store
+	if dbFilename != "" {
+		if store, err = NewStore(dbFilename); err != nil {
+			panic(err)
+		}
+	}
+
+	var alerter *Alerter
+	if len(config.Alerts) > 0 {
+		alerter = NewAlerter(config.Alerts)
+		if replayFilename != "" {
+			log.Println("Replaying, alerts are only logged")
+			alerter.DryRun = true
+		}
+	}
+
+	var recorder Recorder
+	recorder.History = historyN
+	recorder.Aggregations = aggs
+	recorder.Tenants = len(sources) > 1 || sources[0].Name != ""
 	recorder.Reset()
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		now := time.Now()
-		line, err := ParseCTLine(scanner.Text())
-		if err != nil {
+
+	flush := func(end time.Time) {
+		window := recorder.CollectAndReset(end)
+		if enricher != nil {
+			// History shares the items with the query API, annotate a copy
+			window.Items = slices.Clone(window.Items)
+			enricher.Enrich(window.Items, true)
+		}
+		dumped := window
+		dumped.Items = slices.Clone(window.Items)
+		sortItemsBy(dumped.Items, sortKey)
+		if err := dumpWindow(&out, dumped, cols, len(recorder.Aggregations) > 1, recorder.ShowTenants()); err != nil {
 			log.Println(err)
-			continue
 		}
-		if !ctFilter(line) {
-			continue
+		if influx != nil {
+			influx.Write(window)
 		}
-		recorder.Record(line)
-		if now.Minute() != last.Minute() {
-			recorder.DumpAndReset(outFile)
+		if store != nil {
+			if err := store.Add(window); err != nil {
+				log.Println(err)
+			}
+		}
+		if alerter != nil {
+			alerter.Evaluate(window)
 		}
-		last = now
+		if blocklist != nil {
+			blocklist.Evaluate(window)
+		}
+	}
+
+	if replayFilename != "" {
+		if err := replayFile(replayFilename, &recorder, windowLen, flush); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	if err := sanityCheck(); err != nil {
+		log.Println("Warning: sanity check failed:", err)
+	}
+
+	args := []string{"-E", "-e", "DESTROY", "-p", "tcp"}
+	var outputs []string
+	if liveInterval > 0 {
+		outputs = append(outputs, "id")
+	}
+	if kernelTimestamps() {
+		outputs = append(outputs, "ktimestamp")
+	}
+	if len(outputs) > 0 {
+		args = append(args, "-o", strings.Join(outputs, ","))
+	}
+	// Each namespace has its own conntrack table and event stream
+	cmds := make([]*exec.Cmd, 0, len(sources))
+	done := make(chan Source, len(sources))
+	for _, source := range sources {
+		cmd := source.Command(args...)
+		reader, err := cmd.StdoutPipe()
+		if err != nil {
+			panic(err)
+		}
+		if err = cmd.Start(); err != nil {
+			panic(err)
+		}
+		defer cmd.Wait()
+		cmds = append(cmds, cmd)
+
+		var tracker *FlowTracker
+		if liveInterval > 0 {
+			tracker = NewFlowTracker(source)
+			go tracker.SnapshotLoop(liveInterval, recorder.RecordDelta)
+		}
+		go watch(source, reader, tracker, &recorder, done)
+	}
+
+	if httpAddr != "" {
+		api := APIServer{Recorder: &recorder, Blocklist: blocklist, Enricher: enricher}
+		go func() {
+			log.Fatal(api.ListenAndServe(httpAddr))
+		}()
+	}
+
+	quit := make(chan struct{})
+	if topN > 0 {
+		go func() {
+			defer close(quit)
+			view := TopView{Recorder: &recorder, Enricher: enricher, N: topN, SortKey: sortKey, Filter: filter}
+			if err := view.Run(); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	next := alignWindow(time.Now(), windowLen).Add(windowLen)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+loop:
+	for {
+		select {
+		case <-timer.C:
+			flush(next)
+			next = next.Add(windowLen)
+			timer.Reset(time.Until(next))
+		case <-hup:
+			if err := out.Reopen(); err != nil {
+				log.Println(err)
+			}
+		case sig := <-signals:
+			log.Printf("Received %s, exiting\n", sig)
+			break loop
+		case <-quit:
+			break loop
+		case source := <-done:
+			log.Printf("conntrack exited in namespace %s\n", source)
+			break loop
+		}
+	}
+	flush(time.Now())
+	for _, cmd := range cmds {
+		cmd.Process.Kill()
 	}
 }
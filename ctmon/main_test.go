@@ -0,0 +1,602 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Sample lines as printed by conntrack -E
+var sampleLines = map[string]string{
+	"ipv4":      "    [DESTROY] tcp      6 src=192.168.1.100 dst=93.184.216.34 sport=51234 dport=443 packets=15 bytes=2345 src=93.184.216.34 dst=192.168.1.100 sport=443 dport=51234 packets=12 bytes=9876 [ASSURED]",
+	"ipv6":      "    [DESTROY] tcp      6 src=2001:db8::100 dst=2606:2800:220:1:248:1893:25c8:1946 sport=51234 dport=443 packets=10 bytes=1500 src=2606:2800:220:1:248:1893:25c8:1946 dst=2001:db8::100 sport=443 dport=51234 packets=8 bytes=6000 [ASSURED]",
+	"mapped":    "    [DESTROY] tcp      6 src=::ffff:198.51.100.7 dst=::ffff:192.0.2.1 sport=40000 dport=80 packets=11 bytes=1200 src=::ffff:192.0.2.1 dst=::ffff:198.51.100.7 sport=80 dport=40000 packets=9 bytes=4000 [ASSURED]",
+	"unreplied": "    [DESTROY] tcp      6 src=10.0.0.5 dst=10.0.0.1 sport=40000 dport=80 packets=3 bytes=180 [UNREPLIED] src=10.0.0.1 dst=10.0.0.5 sport=80 dport=40000 packets=0 bytes=0",
+	"zone":      "    [DESTROY] tcp      6 src=172.16.0.2 dst=8.8.8.8 sport=41000 dport=443 packets=12 bytes=2000 src=8.8.8.8 dst=172.16.0.2 sport=443 dport=41000 packets=10 bytes=8000 [ASSURED] zone=3",
+	"dnat":      "    [DESTROY] tcp      6 src=198.51.100.7 dst=203.0.113.5 sport=40000 dport=443 packets=10 bytes=1000 src=10.0.0.10 dst=198.51.100.7 sport=8443 dport=40000 packets=10 bytes=5000 [ASSURED]",
+	"extended":  "[1760871601.123456]\t    [DESTROY] tcp      6 src=192.168.1.10 dst=1.1.1.1 sport=50001 dport=443 packets=20 bytes=3000 src=1.1.1.1 dst=203.0.113.5 sport=443 dport=50001 packets=30 bytes=90000 [ASSURED] [start=Sun Oct 19 11:00:00 2025] [stop=Sun Oct 19 11:00:42 2025] delta-time=42 id=3735928559",
+}
+
+func TestParseCTLine(t *testing.T) {
+	tests := []struct {
+		name string
+		want CTLine
+	}{
+		{"ipv4", CTLine{
+			Orig:  CTDirection{netip.MustParseAddr("192.168.1.100"), netip.MustParseAddr("93.184.216.34"), 51234, 443, 15, 2345},
+			Reply: CTDirection{netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("192.168.1.100"), 443, 51234, 12, 9876},
+		}},
+		{"ipv6", CTLine{
+			Orig:  CTDirection{netip.MustParseAddr("2001:db8::100"), netip.MustParseAddr("2606:2800:220:1:248:1893:25c8:1946"), 51234, 443, 10, 1500},
+			Reply: CTDirection{netip.MustParseAddr("2606:2800:220:1:248:1893:25c8:1946"), netip.MustParseAddr("2001:db8::100"), 443, 51234, 8, 6000},
+		}},
+		{"mapped", CTLine{
+			Orig:  CTDirection{netip.MustParseAddr("::ffff:198.51.100.7"), netip.MustParseAddr("::ffff:192.0.2.1"), 40000, 80, 11, 1200},
+			Reply: CTDirection{netip.MustParseAddr("::ffff:192.0.2.1"), netip.MustParseAddr("::ffff:198.51.100.7"), 80, 40000, 9, 4000},
+		}},
+		{"unreplied", CTLine{
+			Orig:  CTDirection{netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.1"), 40000, 80, 3, 180},
+			Reply: CTDirection{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.5"), 80, 40000, 0, 0},
+		}},
+		{"zone", CTLine{
+			Orig:   CTDirection{netip.MustParseAddr("172.16.0.2"), netip.MustParseAddr("8.8.8.8"), 41000, 443, 12, 2000},
+			Reply:  CTDirection{netip.MustParseAddr("8.8.8.8"), netip.MustParseAddr("172.16.0.2"), 443, 41000, 10, 8000},
+			Tenant: Tenant{Zone: 3},
+		}},
+		{"extended", CTLine{
+			Orig:     CTDirection{netip.MustParseAddr("192.168.1.10"), netip.MustParseAddr("1.1.1.1"), 50001, 443, 20, 3000},
+			Reply:    CTDirection{netip.MustParseAddr("1.1.1.1"), netip.MustParseAddr("203.0.113.5"), 443, 50001, 30, 90000},
+			ID:       3735928559,
+			Duration: 42,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseCTLine(sampleLines[test.name])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCTLineMalformed(t *testing.T) {
+	lines := []string{
+		"dst=1.2.3.4 src=1.2.3.4",
+		"sport=80",
+		"packets=1 bytes=2",
+		"src=1.1.1.1 src=2.2.2.2 src=3.3.3.3",
+		"src=1.1.1.1 dport=99999",
+		"src=1.1.1.1 packets=-1",
+		"src=not-an-address",
+		"src=1.1.1.1 id=x",
+	}
+	for _, s := range lines {
+		if _, err := ParseCTLine(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestCTFilter(t *testing.T) {
+	for name, want := range map[string]bool{
+		"ipv4":      true,
+		"ipv6":      true,
+		"mapped":    true,
+		"unreplied": false,
+		"zone":      true,
+		"extended":  true,
+	} {
+		line, err := ParseCTLine(sampleLines[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ctFilter(line); got != want {
+			t.Errorf("%s: ctFilter = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var r Recorder
+	r.Reset()
+	for _, name := range []string{"ipv4", "ipv4", "ipv6", "mapped"} {
+		line, err := ParseCTLine(sampleLines[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Record(line)
+	}
+	end := time.Now()
+	window := r.CollectAndReset(end)
+	if !window.End.Equal(end) {
+		t.Errorf("window end = %v, want %v", window.End, end)
+	}
+
+	want := map[string]AcctData{
+		"192.168.1.0/24":  {Packets: 54, Bytes: 24442, OrigPackets: 30, OrigBytes: 4690, ReplyPackets: 24, ReplyBytes: 19752, Flows: 2},
+		"2001:db8::/48":   {Packets: 18, Bytes: 7500, OrigPackets: 10, OrigBytes: 1500, ReplyPackets: 8, ReplyBytes: 6000, Flows: 1},
+		"198.51.100.0/24": {Packets: 20, Bytes: 5200, OrigPackets: 11, OrigBytes: 1200, ReplyPackets: 9, ReplyBytes: 4000, Flows: 1},
+	}
+	if len(window.Items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(window.Items), len(want))
+	}
+	for i, item := range window.Items {
+		data, ok := want[item.Addr.String()]
+		if !ok {
+			t.Errorf("unexpected prefix %s", item.Addr)
+			continue
+		}
+		item.AcctData.Sample = netip.Addr{}
+		if item.AcctData != data {
+			t.Errorf("%s: got %+v, want %+v", item.Addr, item.AcctData, data)
+		}
+		if i > 0 && window.Items[i-1].Bytes < item.Bytes {
+			t.Errorf("items not sorted by bytes")
+		}
+	}
+
+	if window := r.Snapshot(); len(window.Items) != 0 {
+		t.Errorf("recorder not reset: %v", window.Items)
+	}
+}
+
+func TestRecorderShowTenants(t *testing.T) {
+	var r Recorder
+	r.Reset()
+	line, err := ParseCTLine(sampleLines["ipv4"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Record(line)
+	if r.ShowTenants() {
+		t.Error("tenants shown without namespaces or zones")
+	}
+	zoned, err := ParseCTLine(sampleLines["zone"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Record(zoned)
+	r.CollectAndReset(time.Now())
+
+	// The column stays in windows without zones
+	r.Record(line)
+	if !r.ShowTenants() {
+		t.Error("tenants not shown after a zone was seen")
+	}
+	var buf strings.Builder
+	if err := dumpWindow(&buf, r.CollectAndReset(time.Now()), defaultColumns, false, r.ShowTenants()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "  " + strings.Repeat(" ", 16) + "  "; !strings.Contains(buf.String(), "\n"+want) {
+		t.Errorf("missing empty tenant column in %q", buf.String())
+	}
+}
+
+func TestRecorderAggregations(t *testing.T) {
+	aggs, err := ParseAggregations("client,public/32,peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Recorder{Aggregations: aggs}
+	r.Reset()
+	line, err := ParseCTLine(sampleLines["extended"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !line.SNAT() || line.DNAT() {
+		t.Errorf("SNAT = %v, DNAT = %v", line.SNAT(), line.DNAT())
+	}
+	r.Record(line)
+
+	got := make(map[string]string)
+	for _, item := range r.Snapshot().Items {
+		got[item.Aggregation] = item.Addr.String()
+	}
+	want := map[string]string{
+		"client":    "192.168.1.0/24",
+		"public/32": "203.0.113.5/32",
+		"peer":      "1.1.1.0/24",
+	}
+	for agg, prefix := range want {
+		if got[agg] != prefix {
+			t.Errorf("%s: got %s, want %s", agg, got[agg], prefix)
+		}
+	}
+
+	// A port forward is accounted to the internal server, not the public address
+	line, err = ParseCTLine(sampleLines["dnat"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line.SNAT() || !line.DNAT() {
+		t.Errorf("SNAT = %v, DNAT = %v", line.SNAT(), line.DNAT())
+	}
+	server, err := ParseAggregation("server/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := server.Key(line); addr != netip.MustParseAddr("10.0.0.10") {
+		t.Errorf("server = %s, want 10.0.0.10", addr)
+	}
+}
+
+func TestAggregationMatches(t *testing.T) {
+	tests := []struct {
+		want, name string
+		matches    bool
+	}{
+		{"", "client", true},
+		{"", "client/32", true},
+		{"", "peer", false},
+		{"", "public/32", false},
+		{"peer", "peer", true},
+		{"peer", "peer/16", false},
+		{"client", "client/32", false},
+	}
+	for _, test := range tests {
+		if got := aggregationMatches(test.want, test.name); got != test.matches {
+			t.Errorf("aggregationMatches(%q, %q) = %v, want %v", test.want, test.name, got, test.matches)
+		}
+	}
+}
+
+func TestAlignWindow(t *testing.T) {
+	india := time.FixedZone("IST", 5*3600+1800)
+	nepal := time.FixedZone("NPT", 5*3600+2700)
+	tests := []struct {
+		t         time.Time
+		windowLen time.Duration
+		want      time.Time
+	}{
+		{time.Date(2026, 10, 19, 11, 42, 10, 0, india), time.Hour, time.Date(2026, 10, 19, 11, 0, 0, 0, india)},
+		{time.Date(2026, 10, 19, 11, 42, 10, 0, nepal), 5 * time.Minute, time.Date(2026, 10, 19, 11, 40, 0, 0, nepal)},
+		{time.Date(2026, 10, 19, 3, 0, 0, 0, india), 24 * time.Hour, time.Date(2026, 10, 19, 0, 0, 0, 0, india)},
+		{time.Date(2026, 10, 19, 23, 59, 59, 0, time.UTC), 15 * time.Minute, time.Date(2026, 10, 19, 23, 45, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := alignWindow(test.t, test.windowLen); !got.Equal(test.want) {
+			t.Errorf("alignWindow(%v, %v) = %v, want %v", test.t, test.windowLen, got, test.want)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	input := strings.Join([]string{
+		"[1760871601.1]\t" + strings.TrimSpace(sampleLines["ipv4"]),
+		"[1760871630.2]\t" + strings.TrimSpace(sampleLines["ipv6"]),
+		strings.TrimSpace(sampleLines["ipv4"]), // no timestamp, skipped
+		"[1760871725.3]\t" + strings.TrimSpace(sampleLines["ipv4"]),
+	}, "\n")
+	var (
+		recorder Recorder
+		windows  []Window
+	)
+	recorder.Reset()
+	flush := func(end time.Time) {
+		windows = append(windows, recorder.CollectAndReset(end))
+	}
+	if err := Replay(strings.NewReader(input), &recorder, time.Minute, flush); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1760871600, 0)
+	wantItems := []int{2, 0, 1}
+	if len(windows) != len(wantItems) {
+		t.Fatalf("got %d windows, want %d", len(windows), len(wantItems))
+	}
+	for i, window := range windows {
+		wantStart := start.Add(time.Duration(i) * time.Minute)
+		if !window.Start.Equal(wantStart) || !window.End.Equal(wantStart.Add(time.Minute)) {
+			t.Errorf("window %d: %v - %v", i, window.Start, window.End)
+		}
+		if len(window.Items) != wantItems[i] {
+			t.Errorf("window %d: got %d items, want %d", i, len(window.Items), wantItems[i])
+		}
+	}
+}
+
+func TestEncodeAcct(t *testing.T) {
+	data := AcctData{Packets: 1, Bytes: 2, OrigPackets: 3, OrigBytes: 4, ReplyPackets: 5, ReplyBytes: 6, Flows: 7, Duration: 1 << 40}
+	b := encodeAcct(data)
+	if len(b) != 64 {
+		t.Fatalf("encoded length = %d, want 64", len(b))
+	}
+	got, err := decodeAcct(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != data {
+		t.Errorf("got %+v, want %+v", got, data)
+	}
+	if _, err := decodeAcct(b[:63]); err == nil {
+		t.Error("decoding a short record succeeded")
+	}
+}
+
+func TestStoreKey(t *testing.T) {
+	tests := []struct {
+		key  acctKey
+		want string
+	}{
+		{acctKey{Aggregation: "client", Prefix: netip.MustParsePrefix("192.168.1.0/24")}, "client 192.168.1.0/24"},
+		{acctKey{Aggregation: "peer/64", Prefix: netip.MustParsePrefix("2001:db8::/64")}, "peer/64 2001:db8::/64"},
+		{acctKey{Tenant{"blue", 0}, "client", netip.MustParsePrefix("10.0.0.0/24")}, "client 10.0.0.0/24 blue"},
+		{acctKey{Tenant{"", 3}, "client", netip.MustParsePrefix("10.0.0.0/24")}, "client 10.0.0.0/24 zone=3"},
+		{acctKey{Tenant{"blue", 3}, "client", netip.MustParsePrefix("10.0.0.0/24")}, "client 10.0.0.0/24 blue,zone=3"},
+	}
+	for _, test := range tests {
+		got := storeKey(test.key)
+		if string(got) != test.want {
+			t.Errorf("storeKey(%v) = %q, want %q", test.key, got, test.want)
+		}
+		key, err := parseStoreKey(got)
+		if err != nil {
+			t.Errorf("parseStoreKey(%q): %v", got, err)
+		} else if key != test.key {
+			t.Errorf("parseStoreKey(%q) = %v, want %v", got, key, test.key)
+		}
+	}
+	for _, s := range []string{"", "client", "client 10.0.0.1", "client 10.0.0.0/24 zone=x"} {
+		if _, err := parseStoreKey([]byte(s)); err == nil {
+			t.Errorf("parseStoreKey(%q) succeeded", s)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "ctmon.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := netip.MustParsePrefix("192.168.1.0/24")
+	blue := acctKey{Tenant{"blue", 0}, "client", client}
+	window := func(start time.Time, bytes uint64, keys ...acctKey) Window {
+		w := Window{Start: start, End: start.Add(time.Minute)}
+		for _, k := range keys {
+			w.Items = append(w.Items, sortItem{Tenant: k.Tenant, Aggregation: k.Aggregation, Addr: k.Prefix,
+				AcctData: AcctData{Packets: 1, Bytes: bytes, Flows: 1}})
+		}
+		return w
+	}
+	own := acctKey{Aggregation: "client", Prefix: client}
+	old := time.Date(2026, 6, 1, 10, 30, 0, 0, time.Local)
+	now := time.Date(2026, 10, 19, 11, 0, 0, 0, time.Local)
+	for _, w := range []Window{
+		window(old, 100, own),
+		window(now.Add(-2*time.Hour), 10, own, blue),
+		window(now.Add(-time.Hour), 20, own),
+		window(now, 40, own),
+	} {
+		if err := store.Add(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		bucket, from, to string
+		want             map[acctKey]uint64 // bytes
+	}{
+		{"hour", "2026-10-19T09", "2026-10-19T09", map[acctKey]uint64{own: 10, blue: 10}},
+		{"hour", "2026-10-19T10", "2026-10-19T11", map[acctKey]uint64{own: 60}},
+		{"day", "2026-10-19", "2026-10-19", map[acctKey]uint64{own: 70, blue: 10}},
+		{"month", "2026-06", "2026-10", map[acctKey]uint64{own: 170, blue: 10}},
+		// Pruned after 90 days, the daily and monthly totals are kept
+		{"hour", "2026-06-01T10", "2026-06-01T10", map[acctKey]uint64{}},
+		{"day", "2026-06-01", "2026-06-01", map[acctKey]uint64{own: 100}},
+		{"week", "2026-10", "2026-10", map[acctKey]uint64{}},
+	}
+	for _, test := range tests {
+		items, err := store.Query(test.bucket, test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[acctKey]uint64)
+		for _, item := range items {
+			got[item.key()] = item.Bytes
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s %s..%s: got %v, want %v", test.bucket, test.from, test.to, got, test.want)
+			continue
+		}
+		for k, bytes := range test.want {
+			if got[k] != bytes {
+				t.Errorf("%s %s..%s: %v has %d bytes, want %d", test.bucket, test.from, test.to, k, got[k], bytes)
+			}
+		}
+	}
+
+	if _, err := (&Store{filepath.Join(t.TempDir(), "missing.db")}).Query("day", "2026-10-19", "2026-10-19"); err == nil {
+		t.Error("querying a missing database succeeded")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	b := NewBlocklist(BlocklistConfig{Threshold: Threshold{Bytes: 1000}, DryRun: true}, []Source{{}, {"blue", "/run/netns/blue"}})
+	prefix := netip.MustParsePrefix("192.168.1.0/24")
+	end := time.Now()
+	window := Window{Start: end.Add(-time.Minute), End: end}
+	for _, item := range []sortItem{
+		{Aggregation: "client", Addr: prefix},
+		{Tenant: Tenant{"blue", 0}, Aggregation: "client", Addr: prefix},
+		{Tenant: Tenant{"blue", 3}, Aggregation: "client", Addr: prefix},
+		{Aggregation: "peer", Addr: netip.MustParsePrefix("203.0.113.0/24")},
+		{Aggregation: "client", Addr: netip.MustParsePrefix("10.0.0.0/24"), AcctData: AcctData{Bytes: 10}},
+	} {
+		if item.Bytes == 0 {
+			item.Bytes = 5000
+		}
+		window.Items = append(window.Items, item)
+	}
+	b.Evaluate(window)
+
+	// Zones of a namespace share its sets
+	got := make(map[string]bool)
+	for _, ban := range b.Bans() {
+		got[ban.Namespace+" "+ban.Prefix.String()] = true
+	}
+	want := map[string]bool{" 192.168.1.0/24": true, "blue 192.168.1.0/24": true}
+	if len(got) != len(want) {
+		t.Fatalf("got bans %v, want %v", got, want)
+	}
+	for ban := range want {
+		if !got[ban] {
+			t.Errorf("missing ban %q", ban)
+		}
+	}
+}
+
+func TestAlerterDryRun(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "fired")
+	a := NewAlerter([]AlertRule{{Name: "heavy", Threshold: Threshold{Bytes: 1000}, Command: "touch " + marker}})
+	a.DryRun = true
+	end := time.Now()
+	a.Evaluate(Window{Start: end.Add(-time.Minute), End: end, Items: []sortItem{
+		{Aggregation: "client", Addr: netip.MustParsePrefix("192.168.1.0/24"), AcctData: AcctData{Bytes: 5000}},
+	}})
+	if len(a.fired) != 1 {
+		t.Errorf("got %d fired alerts, want 1", len(a.fired))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("dry run ran the alert command")
+	}
+}
+
+func TestRotateWriter(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "conntrack.log")
+	if err := os.WriteFile(filename, []byte("yesterday\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	if err := os.Chtimes(filename, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart does not keep appending today's lines to yesterday's file
+	w := RotateWriter{Filename: filename, Daily: true, MaxSize: 100}
+	if err := w.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("today\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filename); string(b) != "today\n" {
+		t.Errorf("after daily rotation the file contains %q", b)
+	}
+	if matches, _ := filepath.Glob(filename + ".*"); len(matches) != 1 {
+		t.Errorf("got rotated files %v, want 1", matches)
+	}
+
+	// A failed rotation keeps writing to the current file
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	if n, err := w.Write(line); err != nil || n != len(line) {
+		t.Errorf("Write after a failed rotation = %d, %v", n, err)
+	}
+}
+
+func TestRotateWriterCompress(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "conntrack.log")
+	w := RotateWriter{Filename: filename, MaxSize: 10, Compress: "gzip"}
+	if err := w.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first line\n", "second line\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close waits for the compression
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "conntrack.log" || !strings.HasSuffix(names[1], ".gz") {
+		t.Errorf("got files %v, want conntrack.log and a compressed rotated file", names)
+	}
+}
+
+func FuzzParseCTLine(f *testing.F) {
+	for _, s := range sampleLines {
+		f.Add(s)
+	}
+	f.Add("dst=1.2.3.4 sport=1 dport=2 packets=3 bytes=4")
+	f.Add("src=1.1.1.1 src=2.2.2.2 src=3.3.3.3")
+	f.Fuzz(func(t *testing.T, s string) {
+		line, err := ParseCTLine(s)
+		if err != nil || !line.Orig.Src.IsValid() {
+			return
+		}
+		var r Recorder
+		r.Reset()
+		r.Record(line)
+	})
+}
+
+func benchmarkLines(b *testing.B) []CTLine {
+	lines := make([]CTLine, 0, 256)
+	base, err := ParseCTLine(sampleLines["ipv4"])
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < cap(lines); i++ {
+		line := base
+		line.Orig.Src = netip.AddrFrom4([4]byte{10, byte(i % 16), byte(i), 1})
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func BenchmarkParseCTLine(b *testing.B) {
+	s := sampleLines["ipv4"]
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseCTLine(s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRecord(b *testing.B) {
+	lines := benchmarkLines(b)
+	var r Recorder
+	r.Reset()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Record(lines[i%len(lines)])
+	}
+}
+
+func BenchmarkRecordParallel(b *testing.B) {
+	lines := benchmarkLines(b)
+	var (
+		r Recorder
+		n atomic.Uint64
+	)
+	r.Reset()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Record(lines[n.Add(1)%uint64(len(lines))])
+		}
+	})
+}
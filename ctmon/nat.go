@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// SNAT reports whether the source address was translated, i.e. replies go to another address
+func (l CTLine) SNAT() bool {
+	return l.Reply.Dst != l.Orig.Src
+}
+
+// DNAT reports whether the destination address was translated, i.e. replies come from another address
+func (l CTLine) DNAT() bool {
+	return l.Reply.Src != l.Orig.Dst
+}
+
+// Attributes select the address a flow is accounted to
+var attributes = map[string]func(CTLine) netip.Addr{
+	// client is the initiator before any SNAT, e.g. the internal host behind a NAT gateway
+	"client": func(l CTLine) netip.Addr { return l.Orig.Src },
+	// public is the initiator as seen by the peer, i.e. the SNAT address if any
+	"public": func(l CTLine) netip.Addr {
+		if l.SNAT() {
+			return l.Reply.Dst
+		}
+		return l.Orig.Src
+	},
+	// peer is the destination the client connected to
+	"peer": func(l CTLine) netip.Addr { return l.Orig.Dst },
+	// server is the destination after any DNAT, e.g. the internal host behind a port forward
+	"server": func(l CTLine) netip.Addr {
+		if l.DNAT() {
+			return l.Reply.Src
+		}
+		return l.Orig.Dst
+	},
+}
+
+// aggregationMatches reports whether a rule for aggregation want applies to the aggregation name.
+// Rules without an aggregation only apply to client aggregations, the other attributes may be
+// our own addresses, like the SNAT address of a gateway or the peer address of a web server.
+func aggregationMatches(want, name string) bool {
+	if want == "" {
+		attribute, _, _ := strings.Cut(name, "/")
+		return attribute == "client"
+	}
+	return want == name
+}
+
+// Aggregation accounts flows to prefixes of one attributed address
+type Aggregation struct {
+	Name      string
+	attribute func(CTLine) netip.Addr
+	bits4     int
+	bits6     int
+}
+
+var defaultAggregations = []Aggregation{{"client", attributes["client"], 24, 48}}
+
+// ParseAggregation parses "attribute[/bits4[/bits6]]", e.g. "client", "public/32" or "peer/24/48"
+func ParseAggregation(s string) (Aggregation, error) {
+	parts := strings.Split(s, "/")
+	attribute, ok := attributes[parts[0]]
+	if !ok {
+		return Aggregation{}, fmt.Errorf("unknown attribute: %s", parts[0])
+	}
+	if len(parts) > 3 {
+		return Aggregation{}, fmt.Errorf("invalid aggregation: %s", s)
+	}
+	agg := Aggregation{Name: s, attribute: attribute, bits4: 24, bits6: 48}
+	var err error
+	if len(parts) > 1 {
+		if agg.bits4, err = strconv.Atoi(parts[1]); err != nil || agg.bits4 < 0 || agg.bits4 > 32 {
+			return Aggregation{}, fmt.Errorf("invalid IPv4 prefix length: %s", parts[1])
+		}
+	}
+	if len(parts) > 2 {
+		if agg.bits6, err = strconv.Atoi(parts[2]); err != nil || agg.bits6 < 0 || agg.bits6 > 128 {
+			return Aggregation{}, fmt.Errorf("invalid IPv6 prefix length: %s", parts[2])
+		}
+	}
+	return agg, nil
+}
+
+func ParseAggregations(s string) ([]Aggregation, error) {
+	var aggs []Aggregation
+	for _, spec := range strings.Split(s, ",") {
+		agg, err := ParseAggregation(spec)
+		if err != nil {
+			return nil, err
+		}
+		aggs = append(aggs, agg)
+	}
+	return aggs, nil
+}
+
+// Key returns the attributed address and its prefix
+func (a Aggregation) Key(line CTLine) (netip.Addr, netip.Prefix) {
+	addr := a.attribute(line).Unmap()
+	bits := a.bits4
+	if addr.Is6() {
+		bits = a.bits6
+	}
+	prefix, _ := addr.Prefix(bits)
+	return addr, prefix
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Tenant identifies the conntrack table a flow was seen in, the zero value is ctmon's own namespace and zone 0
+type Tenant struct {
+	Namespace string `json:"namespace,omitempty"`
+	Zone      uint16 `json:"zone,omitempty"`
+}
+
+// String formats a tenant like "blue,zone=3", or "" for the zero value
+func (t Tenant) String() string {
+	var parts []string
+	if t.Namespace != "" {
+		parts = append(parts, t.Namespace)
+	}
+	if t.Zone != 0 {
+		parts = append(parts, fmt.Sprintf("zone=%d", t.Zone))
+	}
+	return strings.Join(parts, ",")
+}
+
+func ParseTenant(s string) (t Tenant, err error) {
+	if s == "" {
+		return t, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		if zone, ok := strings.CutPrefix(part, "zone="); ok {
+			value, err := strconv.ParseUint(zone, 10, 16)
+			if err != nil {
+				return t, fmt.Errorf("invalid zone: %s", zone)
+			}
+			t.Zone = uint16(value)
+		} else {
+			t.Namespace = part
+		}
+	}
+	return t, nil
+}
+
+// Source is a network namespace whose conntrack table is watched
+type Source struct {
+	Name string // empty for ctmon's own namespace
+	Path string // namespace file to enter, empty for ctmon's own namespace
+}
+
+// ParseSource parses "self", a PID, a name under /run/netns or an absolute namespace file path
+func ParseSource(s string) (Source, error) {
+	switch {
+	case s == "self":
+		return Source{}, nil
+	case s == "":
+		return Source{}, fmt.Errorf("empty namespace")
+	case filepath.IsAbs(s):
+		return Source{s, s}, nil
+	}
+	if _, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return Source{s, "/proc/" + s + "/ns/net"}, nil
+	}
+	if strings.Contains(s, "/") {
+		return Source{}, fmt.Errorf("invalid namespace: %s", s)
+	}
+	return Source{s, "/run/netns/" + s}, nil
+}
+
+func ParseSources(s string) ([]Source, error) {
+	var sources []Source
+	for _, spec := range strings.Split(s, ",") {
+		source, err := ParseSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// Command runs conntrack inside the namespace of s
+func (s Source) Command(args ...string) *exec.Cmd {
+	return s.Exec("conntrack", args...)
+}
+
+// Exec runs a program inside the namespace of s
+func (s Source) Exec(name string, args ...string) *exec.Cmd {
+	if s.Path == "" {
+		return exec.Command(name, args...)
+	}
+	return exec.Command("nsenter", append([]string{"--net=" + s.Path, name}, args...)...)
+}
+
+func (s Source) String() string {
+	if s.Name == "" {
+		return "self"
+	}
+	return s.Name
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimestamp extracts the leading "[1697712345.123456]" of conntrack -o timestamp output
+func parseTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		return time.Time{}, false
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	sec, frac, _ := strings.Cut(s[1:end], ".")
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsecs int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if nsecs, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(secs, nsecs), true
+}
+
+// Replay feeds saved "conntrack -E -o timestamp,ktimestamp" output through the accounting,
+// closing windows by event time instead of wall clock. Without ktimestamp flow durations are 0.
+func Replay(r io.Reader, recorder *Recorder, windowLen time.Duration, flush func(time.Time)) error {
+	var next time.Time
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if strings.Contains(text, "[NEW]") || strings.Contains(text, "[UPDATE]") {
+			continue
+		}
+		t, ok := parseTimestamp(text)
+		if !ok {
+			log.Printf("line %d: missing timestamp, record with conntrack -E -o timestamp,ktimestamp\n", n)
+			continue
+		}
+		if next.IsZero() {
+			recorder.ResetAt(alignWindow(t, windowLen))
+			next = alignWindow(t, windowLen).Add(windowLen)
+		}
+		for !t.Before(next) {
+			flush(next)
+			next = next.Add(windowLen)
+		}
+
+		line, err := ParseCTLine(text)
+		if err != nil {
+			log.Printf("line %d: %s\n", n, err)
+			continue
+		}
+		if ctFilter(line) {
+			recorder.Record(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !next.IsZero() {
+		flush(next)
+	}
+	return nil
+}
+
+func replayFile(filename string, recorder *Recorder, windowLen time.Duration, flush func(time.Time)) error {
+	if filename == "-" {
+		return Replay(os.Stdin, recorder, windowLen, flush)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Replay(f, recorder, windowLen, flush)
+}
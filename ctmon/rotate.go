@@ -0,0 +1,216 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// RotateWriter is an append-only log file that rotates by size or by day.
+// Rotated files are optionally compressed and only the newest Keep of them are retained.
+type RotateWriter struct {
+	Filename string
+	MaxSize  int64  // rotate before the file grows beyond this many bytes, 0 to disable
+	Daily    bool   // rotate when the local date changes
+	Compress string // "", "gzip" or "zstd"
+	Keep     int    // number of rotated files to keep, 0 for unlimited
+
+	mu       sync.Mutex
+	finishes sync.WaitGroup // compression and cleanup of rotated files
+	file     *os.File
+	size     int64
+	opened   time.Time // last modification of an existing file, for daily rotation after a restart
+}
+
+func (w *RotateWriter) open() error {
+	f, err := os.OpenFile(w.Filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	w.opened = info.ModTime()
+	return nil
+}
+
+func (w *RotateWriter) Open() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.open()
+}
+
+// Reopen closes and reopens the file, for use after it was moved by an external logrotate
+func (w *RotateWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+	}
+	return w.open()
+}
+
+// Close closes the file and waits until rotated files are compressed
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer w.finishes.Wait()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *RotateWriter) needRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.MaxSize > 0 && w.size+int64(n) > w.MaxSize {
+		return true
+	}
+	if w.Daily {
+		y1, m1, d1 := w.opened.Date()
+		y2, m2, d2 := time.Now().Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+// rotate renames the file and opens a new one. On failure the current file stays open.
+func (w *RotateWriter) rotate() error {
+	rotated := w.Filename + "." + time.Now().Format("20060102-150405")
+	for i := 1; ; i++ {
+		matches, _ := filepath.Glob(rotated + "*")
+		if len(matches) == 0 {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d", w.Filename, time.Now().Format("20060102-150405"), i)
+	}
+	if err := os.Rename(w.Filename, rotated); err != nil {
+		return err
+	}
+	old := w.file
+	if err := w.open(); err != nil {
+		// Appending to the rotated file is better than losing output
+		return err
+	}
+	if err := old.Close(); err != nil {
+		log.Println(err)
+	}
+	w.finishes.Add(1)
+	go func() {
+		defer w.finishes.Done()
+		w.finish(rotated)
+	}()
+	return nil
+}
+
+// Write writes p as a whole, rotating the file beforehand if needed
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.needRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			// Keep writing to the current file, rotation is retried on the next write
+			log.Println("rotate:", err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func compressFile(filename, method string) (string, error) {
+	var (
+		ext       string
+		newWriter func(io.Writer) (io.WriteCloser, error)
+	)
+	switch method {
+	case "gzip":
+		ext = ".gz"
+		newWriter = func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+	case "zstd":
+		ext = ".zst"
+		newWriter = func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+	default:
+		return "", fmt.Errorf("unknown compression: %s", method)
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	// Compress to a hidden name, so that an interrupted run leaves no partial file
+	// matching the rotated names, and the next attempt simply overwrites it
+	tmp := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+ext)
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	zw, err := newWriter(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, filename+ext); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return filename + ext, os.Remove(filename)
+}
+
+// finish compresses a rotated file and removes old ones beyond retention
+func (w *RotateWriter) finish(rotated string) {
+	if w.Compress != "" {
+		if _, err := compressFile(rotated, w.Compress); err != nil {
+			log.Println("compress:", err)
+		}
+	}
+	if w.Keep <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(w.Filename + ".[0-9]*")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	// Timestamped names sort chronologically
+	slices.Sort(matches)
+	for len(matches) > w.Keep {
+		if err := os.Remove(matches[0]); err != nil {
+			log.Println(err)
+		}
+		matches = matches[1:]
+	}
+}
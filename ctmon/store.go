@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Periods of the rolled-up totals, keyed by the local start time in the given layout
+var storePeriods = []struct {
+	bucket string
+	layout string
+}{
+	{"hour", "2006-01-02T15"},
+	{"day", "2006-01-02"},
+	{"month", "2006-01"},
+}
+
+// hourlyRetention is how long hourly totals are kept, daily and monthly totals are kept forever
+const hourlyRetention = 90 * 24 * time.Hour
+
+// Store keeps hourly, daily and monthly totals per prefix in a bbolt database.
+// Each period bucket holds one bucket per period, mapping "aggregation prefix" to encoded AcctData.
+// The database is only open while a window is added or a query runs: bbolt locks the file
+// exclusively while it is open for writing, and ctmon query has to read it while ctmon runs.
+type Store struct {
+	Filename string
+}
+
+// storeTimeout is how long to wait for the lock of another process using the database
+const storeTimeout = 10 * time.Second
+
+// NewStore creates the database file if needed, so that unusable paths fail at startup
+func NewStore(filename string) (*Store, error) {
+	s := &Store{filename}
+	db, err := s.open(false)
+	if err != nil {
+		return nil, err
+	}
+	return s, db.Close()
+}
+
+func (s *Store) open(readOnly bool) (*bolt.DB, error) {
+	if readOnly {
+		// A read-only open does not create the file
+		if _, err := os.Stat(s.Filename); err != nil {
+			return nil, err
+		}
+	}
+	return bolt.Open(s.Filename, 0644, &bolt.Options{Timeout: storeTimeout, ReadOnly: readOnly})
+}
+
+func (d *AcctData) add(o AcctData) {
+	d.Packets += o.Packets
+	d.Bytes += o.Bytes
+	d.OrigPackets += o.OrigPackets
+	d.OrigBytes += o.OrigBytes
+	d.ReplyPackets += o.ReplyPackets
+	d.ReplyBytes += o.ReplyBytes
+	d.Flows += o.Flows
+	d.Duration += o.Duration
+}
+
+func encodeAcct(d AcctData) []byte {
+	b := make([]byte, 0, 64)
+	for _, v := range []uint64{d.Packets, d.Bytes, d.OrigPackets, d.OrigBytes, d.ReplyPackets, d.ReplyBytes, d.Flows, d.Duration} {
+		b = binary.BigEndian.AppendUint64(b, v)
+	}
+	return b
+}
+
+func decodeAcct(b []byte) (d AcctData, err error) {
+	if len(b) != 64 {
+		return d, fmt.Errorf("invalid record length %d", len(b))
+	}
+	for i, p := range []*uint64{&d.Packets, &d.Bytes, &d.OrigPackets, &d.OrigBytes, &d.ReplyPackets, &d.ReplyBytes, &d.Flows, &d.Duration} {
+		*p = binary.BigEndian.Uint64(b[i*8:])
+	}
+	return d, nil
+}
+
+// storeKey formats a key as "aggregation prefix[ tenant]"
+func storeKey(key acctKey) []byte {
+	s := key.Aggregation + " " + key.Prefix.String()
+	if key.Tenant != (Tenant{}) {
+		s += " " + key.Tenant.String()
+	}
+	return []byte(s)
+}
+
+func parseStoreKey(b []byte) (key acctKey, err error) {
+	fields := strings.SplitN(string(b), " ", 3)
+	if len(fields) < 2 {
+		return key, fmt.Errorf("invalid key %q", b)
+	}
+	key.Aggregation = fields[0]
+	if key.Prefix, err = netip.ParsePrefix(fields[1]); err != nil {
+		return key, err
+	}
+	if len(fields) > 2 {
+		key.Tenant, err = ParseTenant(fields[2])
+	}
+	return key, err
+}
+
+// Add adds a window to the totals of the periods containing its start
+func (s *Store) Add(window Window) error {
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	start := window.Start.Local()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, period := range storePeriods {
+			root, err := tx.CreateBucketIfNotExists([]byte(period.bucket))
+			if err != nil {
+				return err
+			}
+			b, err := root.CreateBucketIfNotExists([]byte(start.Format(period.layout)))
+			if err != nil {
+				return err
+			}
+			for _, item := range window.Items {
+				key := storeKey(item.key())
+				var data AcctData
+				if v := b.Get(key); v != nil {
+					if data, err = decodeAcct(v); err != nil {
+						return err
+					}
+				}
+				data.add(item.AcctData)
+				if err := b.Put(key, encodeAcct(data)); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Prune expired hourly totals
+		hours := tx.Bucket([]byte("hour"))
+		oldest := []byte(start.Add(-hourlyRetention).Format(storePeriods[0].layout))
+		var expired [][]byte
+		c := hours.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < string(oldest); k, _ = c.Next() {
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := hours.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Query sums the totals of periods between from and to inclusive, both given in the layout of bucket
+func (s *Store) Query(bucket, from, to string) ([]sortItem, error) {
+	db, err := s.open(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	totals := make(map[acctKey]AcctData)
+	err = db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(bucket))
+		if root == nil {
+			return nil
+		}
+		c := root.Cursor()
+		for k, _ := c.Seek([]byte(from)); k != nil && string(k) <= to; k, _ = c.Next() {
+			err := root.Bucket(k).ForEach(func(k, v []byte) error {
+				key, err := parseStoreKey(k)
+				if err != nil {
+					return err
+				}
+				data, err := decodeAcct(v)
+				if err != nil {
+					return err
+				}
+				total := totals[key]
+				total.add(data)
+				totals[key] = total
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	items := make([]sortItem, 0, len(totals))
+	for k, v := range totals {
+		items = append(items, sortItem{Tenant: k.Tenant, Aggregation: k.Aggregation, Addr: k.Prefix, AcctData: v})
+	}
+	sortItems(items)
+	return items, err
+}
+
+// periodOf finds the period bucket matching the precision of a date like 2026-10, 2026-10-19 or 2026-10-19T11
+func periodOf(date string) (string, error) {
+	for _, period := range storePeriods {
+		if _, err := time.ParseInLocation(period.layout, date, time.Local); err == nil {
+			return period.bucket, nil
+		}
+	}
+	return "", fmt.Errorf("invalid date: %s", date)
+}
+
+// queryMain implements "ctmon query", printing the top consumers over a date range
+func queryMain(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	var (
+		dbFilename, from, to   string
+		sortKey, agg, colsList string
+		tenant                 string
+		topN                   int
+	)
+	today := time.Now().Format("2006-01-02")
+	fs.StringVar(&dbFilename, "db", "ctmon.db", "database file")
+	fs.StringVar(&from, "from", today[:8]+"01", "first `date` (2006-01, 2006-01-02 or 2006-01-02T15)")
+	fs.StringVar(&to, "to", "", "last `date`, inclusive, in the same format as -from (default: same as -from for months and hours, today for days)")
+	fs.IntVar(&topN, "n", 20, "number of prefixes to show, 0 for all")
+	fs.StringVar(&sortKey, "sort", "bytes", "sort key (any column)")
+	fs.StringVar(&agg, "agg", "", "only show this aggregation")
+	fs.StringVar(&tenant, "tenant", "", "only show this `namespace[,zone=N]`, - for ctmon's own namespace and zone 0")
+	fs.StringVar(&colsList, "columns", "packets,bytes,orig_bytes,reply_bytes,flows", "comma-separated columns")
+	fs.Parse(args)
+
+	bucket, err := periodOf(from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if to == "" {
+		to = from
+		if bucket == "day" {
+			to = today
+		}
+	}
+	if toBucket, err := periodOf(to); err != nil || toBucket != bucket {
+		fmt.Fprintln(os.Stderr, "-to must have the same format as -from")
+		os.Exit(2)
+	}
+	cols, err := parseColumns(colsList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	store := Store{Filename: dbFilename}
+	items, err := store.Query(bucket, from, to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if agg != "" {
+		items = slices.DeleteFunc(items, func(item sortItem) bool {
+			return item.Aggregation != agg
+		})
+	}
+	if tenant != "" {
+		items = filterTenant(items, tenant)
+	}
+	if err := sortItemsBy(items, sortKey); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if topN > 0 && len(items) > topN {
+		items = items[:topN]
+	}
+
+	showTenant := hasTenants(items)
+	if showTenant {
+		fmt.Printf("%-16s ", "Tenant")
+	}
+	fmt.Printf("%-12s %20s", "Aggregation", "Prefix")
+	for _, name := range cols {
+		fmt.Printf(" %*s", max(columns[name].width, len(name)), name)
+	}
+	fmt.Println()
+	for _, item := range items {
+		if showTenant {
+			fmt.Printf("%-16s ", item.Tenant)
+		}
+		fmt.Printf("%-12s %20s", item.Aggregation, item.Addr)
+		for _, name := range cols {
+			col := columns[name]
+			fmt.Printf(" %*d", max(col.width, len(name)), col.value(item.AcctData))
+		}
+		fmt.Println()
+	}
+}
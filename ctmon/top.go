@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// TopView is an interactive terminal table of the heaviest prefixes in the current window
+type TopView struct {
+	Recorder *Recorder
+	Enricher *Enricher // optional
+	N        int
+	SortKey  string
+	Filter   netip.Prefix
+
+	editing bool
+	input   []byte
+	message string
+	logs    lastLine
+}
+
+// lastLine is a log output keeping the last line, to show it instead of the help line
+type lastLine struct {
+	mu   sync.Mutex
+	line string
+}
+
+func (l *lastLine) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.line = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+// take returns the last line logged since the previous call, if any
+func (l *lastLine) take() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	line := l.line
+	l.line = ""
+	return line
+}
+
+const topHelp = "b/p/u/d/f/t: sort by bytes/packets/up/down/flows/duration  /: filter  c: clear filter  q: quit"
+
+func formatRate(rate float64) string {
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s"}
+	i := 0
+	for rate >= 1000 && i < len(units)-1 {
+		rate /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", rate, units[i])
+}
+
+func (v *TopView) draw() {
+	if line := v.logs.take(); line != "" {
+		v.message = line
+	}
+	window := v.Recorder.Snapshot()
+	elapsed := window.End.Sub(window.Start).Seconds()
+	items := filterItems(window.Items, v.Filter)
+	sortItemsBy(items, v.SortKey)
+
+	rows := v.N
+	if _, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil && height-4 < rows {
+		rows = max(height-4, 0)
+	}
+	if len(items) > rows {
+		items = items[:rows]
+	}
+	if v.Enricher != nil {
+		v.Enricher.Enrich(items, false)
+	}
+
+	filter := "none"
+	if v.Filter.IsValid() {
+		filter = v.Filter.String()
+	}
+	var buf bytes.Buffer
+	buf.WriteString("\x1B[H\x1B[2J")
+	fmt.Fprintf(&buf, "Window: %s (%.0fs)  Sort: %s  Filter: %s\r\n",
+		window.Start.Format(time.TimeOnly), elapsed, v.SortKey, filter)
+	showTenant := v.Recorder.ShowTenants()
+	if showTenant {
+		fmt.Fprintf(&buf, "%-16s ", "Tenant")
+	}
+	if len(v.Recorder.Aggregations) > 1 {
+		fmt.Fprintf(&buf, "%-12s ", "Aggregation")
+	}
+	fmt.Fprintf(&buf, "%20s %14s %14s %14s %10s %12s %8s %10s\r\n",
+		"Prefix", "Bytes", "Up", "Down", "Packets", "Rate", "Flows", "Duration")
+	showAgg := len(v.Recorder.Aggregations) > 1
+	for _, item := range items {
+		if showTenant {
+			fmt.Fprintf(&buf, "%-16s ", item.Tenant)
+		}
+		if showAgg {
+			fmt.Fprintf(&buf, "%-12s ", item.Aggregation)
+		}
+		rate := 0.0
+		if elapsed > 0 {
+			rate = float64(item.Bytes) / elapsed
+		}
+		fmt.Fprintf(&buf, "%20s %14d %14d %14d %10d %12s %8d %10s  %s\r\n",
+			item.Addr.String(), item.Bytes, item.OrigBytes, item.ReplyBytes, item.Packets,
+			formatRate(rate), item.Flows, time.Duration(item.Duration)*time.Second, item.Info)
+	}
+	switch {
+	case v.editing:
+		fmt.Fprintf(&buf, "\r\nFilter: %s", v.input)
+	case v.message != "":
+		fmt.Fprintf(&buf, "\r\n%s", v.message)
+	default:
+		fmt.Fprintf(&buf, "\r\n%s", topHelp)
+	}
+	os.Stdout.Write(buf.Bytes())
+}
+
+// handleKey processes a single key press and reports whether the view should quit
+func (v *TopView) handleKey(key byte) bool {
+	if v.editing {
+		switch key {
+		case '\r', '\n':
+			v.editing = false
+			filter, err := parseFilter(string(v.input))
+			if err != nil {
+				v.message = err.Error()
+				break
+			}
+			v.Filter = filter
+		case 0x1B: // Esc
+			v.editing = false
+		case 0x7F, '\b':
+			if len(v.input) > 0 {
+				v.input = v.input[:len(v.input)-1]
+			}
+		default:
+			if key >= 0x20 && key < 0x7F {
+				v.input = append(v.input, key)
+			}
+		}
+		return false
+	}
+
+	v.message = ""
+	switch key {
+	case 'q', 0x03: // Ctrl-C
+		return true
+	case 'b':
+		v.SortKey = "bytes"
+	case 'p':
+		v.SortKey = "packets"
+	case 'u':
+		v.SortKey = "orig_bytes"
+	case 'd':
+		v.SortKey = "reply_bytes"
+	case 'f':
+		v.SortKey = "flows"
+	case 't':
+		v.SortKey = "duration"
+	case 'c':
+		v.Filter = netip.Prefix{}
+	case '/':
+		v.editing = true
+		v.input = v.input[:0]
+	}
+	return false
+}
+
+// Run redraws the table every second until the user quits
+func (v *TopView) Run() error {
+	if _, ok := columns[v.SortKey]; !ok {
+		return fmt.Errorf("unknown sort key: %s", v.SortKey)
+	}
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	os.Stdout.WriteString("\x1B[?25l")
+	defer os.Stdout.WriteString("\x1B[?25h\r\n")
+
+	// Log lines like alerts and bans would scribble over the table, the last one is shown instead
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&v.logs)
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		v.draw()
+		select {
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok || v.handleKey(key) {
+				return nil
+			}
+		}
+	}
+}
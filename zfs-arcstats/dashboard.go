@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Dashboard is an interactive full-screen view of the ARC, redrawn after every sample
+type Dashboard struct {
+	Sampler  *Sampler
+	Interval time.Duration
+	Influx   *InfluxSink // optional
+
+	sample  Sample
+	ready   bool
+	history []historyPoint
+	hidden  map[byte]bool // panels toggled off, by key
+	message string
+}
+
+// historyPoint holds the hit ratios of one sample, -1 if there were no accesses
+type historyPoint struct {
+	Time                         time.Time
+	Hit, Demand, Prefetch, L2Hit float64
+}
+
+// maxHistory is enough for the sparklines of a wide terminal
+const maxHistory = 512
+
+// dashboardIntervals are the steps of the + and - keys
+var dashboardIntervals = []time.Duration{
+	250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+type panel struct {
+	key   byte
+	title string
+	draw  func(d *Dashboard, buf *bytes.Buffer, width int)
+}
+
+var panels = []panel{
+	{'a', "ARC size", (*Dashboard).drawSizes},
+	{'r', "Hit ratios", (*Dashboard).drawRatios},
+	{'l', "L2ARC", (*Dashboard).drawL2},
+	{'h', "History", (*Dashboard).drawHistory},
+	{'i', "I/O", (*Dashboard).drawIO},
+}
+
+const dashboardHelp = "+/-: interval  a/r/l/h/i: toggle size/ratios/L2ARC/history/I/O  q: quit"
+
+// nextInterval returns the next longer or shorter step after d, d itself at either end
+func nextInterval(d time.Duration, longer bool) time.Duration {
+	if longer {
+		for _, i := range dashboardIntervals {
+			if i > d {
+				return i
+			}
+		}
+		return d
+	}
+	for j := len(dashboardIntervals) - 1; j >= 0; j-- {
+		if dashboardIntervals[j] < d {
+			return dashboardIntervals[j]
+		}
+	}
+	return d
+}
+
+// bar draws a bar of width cells filled to value/max, with a marker at mark/max if mark > 0
+func bar(value, mark, max float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	cells := []rune(strings.Repeat("░", width))
+	if max > 0 {
+		fill := int(value/max*float64(width) + 0.5)
+		for i := 0; i < fill && i < width; i++ {
+			cells[i] = '█'
+		}
+		if mark > 0 {
+			i := int(mark / max * float64(width))
+			if i >= width {
+				i = width - 1
+			}
+			cells[i] = '┃'
+		}
+	}
+	return string(cells)
+}
+
+// stackedBar draws parts next to each other in width cells, each with its own rune
+func stackedBar(parts []float64, runes []rune, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	var total float64
+	for _, p := range parts {
+		total += p
+	}
+	var b strings.Builder
+	if total <= 0 {
+		return strings.Repeat(" ", width)
+	}
+	var acc float64
+	n := 0
+	for i, p := range parts {
+		// Round the running total so that the cells always add up to width
+		acc += p
+		end := int(acc/total*float64(width) + 0.5)
+		b.WriteString(strings.Repeat(string(runes[i]), end-n))
+		n = end
+	}
+	return b.String()
+}
+
+// sparkline draws the last width percentages of values, blank for negative ones
+func sparkline(values []float64, width int) string {
+	const levels = "▁▂▃▄▅▆▇█"
+	ticks := []rune(levels)
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	var b strings.Builder
+	for _, v := range values {
+		if v < 0 {
+			b.WriteByte(' ')
+			continue
+		}
+		i := int(v / 100 * float64(len(ticks)-1))
+		if i >= len(ticks) {
+			i = len(ticks) - 1
+		}
+		b.WriteRune(ticks[i])
+	}
+	return b.String()
+}
+
+// hitRatio is like Sample.Ratio but -1 if there were no accesses
+func hitRatio(s Sample, hits, total func(Stat) uint64) float64 {
+	if s.Delta(total) == 0 {
+		return -1
+	}
+	return s.Ratio(hits, total)
+}
+
+func formatRatio(v float64) string {
+	if v < 0 {
+		return "    -"
+	}
+	return fmt.Sprintf("%4.1f%%", v)
+}
+
+func (d *Dashboard) add(s Sample) {
+	d.sample, d.ready = s, true
+	d.history = append(d.history, historyPoint{
+		Time:     s.Time,
+		Hit:      hitRatio(s, Stat.Hits, reads),
+		Demand:   hitRatio(s, Stat.DemandHits, demandReads),
+		Prefetch: hitRatio(s, Stat.PrefetchHits, prefetchReads),
+		L2Hit:    hitRatio(s, Stat.L2Hits, l2Reads),
+	})
+	if len(d.history) > maxHistory {
+		d.history = d.history[len(d.history)-maxHistory:]
+	}
+}
+
+func (d *Dashboard) drawSizes(buf *bytes.Buffer, width int) {
+	s := d.sample.Cur
+	size, target, cmax := float64(s.Size()), float64(s.Target()), float64(s.TargetMax())
+	fmt.Fprintf(buf, "  size %s  target %s  min %s  max %s\r\n",
+		humanize(size), humanize(target), humanize(float64(s.TargetMin())), humanize(cmax))
+	pct := 0.0
+	if target > 0 {
+		pct = size / target * 100
+	}
+	fmt.Fprintf(buf, "  %s  %3.0f%% of target\r\n", bar(size, target, cmax, width-20), pct)
+
+	mru, mfu := float64(s.MRUSize()), float64(s.MFUSize())
+	other := size - mru - mfu
+	if other < 0 {
+		other = 0
+	}
+	fmt.Fprintf(buf, "  █ MRU %s  ▓ MFU %s  ░ other %s  metadata %s\r\n",
+		humanize(mru), humanize(mfu), humanize(other), humanize(float64(s.MetadataSize())))
+	fmt.Fprintf(buf, "  %s\r\n", stackedBar([]float64{mru, mfu, other}, []rune("█▓░"), width-4))
+}
+
+func (d *Dashboard) drawRatios(buf *bytes.Buffer, width int) {
+	s := d.sample
+	for _, r := range []struct {
+		name        string
+		hits, total func(Stat) uint64
+	}{
+		{"ARC", Stat.Hits, reads},
+		{"Demand", Stat.DemandHits, demandReads},
+		{"Prefetch", Stat.PrefetchHits, prefetchReads},
+		{"Metadata", metadataHits, metadataReads},
+	} {
+		fmt.Fprintf(buf, "  %-9s %s  %6s reads/s\r\n",
+			r.name, formatRatio(hitRatio(s, r.hits, r.total)), humanize(s.Rate(r.total)))
+	}
+}
+
+func (d *Dashboard) drawL2(buf *bytes.Buffer, width int) {
+	s := d.sample
+	if s.Cur.L2Size() == 0 && s.Delta(l2Reads) == 0 {
+		buf.WriteString("  not present or empty\r\n")
+		return
+	}
+	fmt.Fprintf(buf, "  size %s  allocated %s  compression %.2fx\r\n",
+		humanize(float64(s.Cur.L2Size())), humanize(float64(s.Cur.L2ASize())), s.Cur.L2Compression())
+	fmt.Fprintf(buf, "  hit %s  %s reads/s  read %s/s  written %s/s\r\n",
+		formatRatio(hitRatio(s, Stat.L2Hits, l2Reads)), humanize(s.Rate(l2Reads)),
+		humanize(s.Rate(counter("l2_read_bytes"))), humanize(s.Rate(counter("l2_write_bytes"))))
+}
+
+func (d *Dashboard) drawHistory(buf *bytes.Buffer, width int) {
+	n := width - 14
+	if n < 1 {
+		return
+	}
+	points := d.history
+	if len(points) > n {
+		points = points[len(points)-n:]
+	}
+	span := d.sample.Time.Sub(points[0].Time).Round(time.Second)
+	fmt.Fprintf(buf, "  last %s\r\n", span)
+	for _, l := range []struct {
+		name  string
+		value func(historyPoint) float64
+	}{
+		{"ARC", func(p historyPoint) float64 { return p.Hit }},
+		{"Demand", func(p historyPoint) float64 { return p.Demand }},
+		{"Prefetch", func(p historyPoint) float64 { return p.Prefetch }},
+		{"L2ARC", func(p historyPoint) float64 { return p.L2Hit }},
+	} {
+		values := make([]float64, len(points))
+		for i, p := range points {
+			values[i] = l.value(p)
+		}
+		fmt.Fprintf(buf, "  %-9s  %s\r\n", l.name, sparkline(values, n))
+	}
+}
+
+func (d *Dashboard) drawIO(buf *bytes.Buffer, width int) {
+	if !d.Sampler.ReadIO {
+		buf.WriteString("  start with -io to read per-pool and per-dataset I/O\r\n")
+		return
+	}
+	lines := formatIO(d.sample)
+	if len(lines) == 0 {
+		buf.WriteString("  no pools\r\n")
+	}
+	for _, line := range lines {
+		buf.WriteString(line + "\r\n")
+	}
+}
+
+func (d *Dashboard) draw() {
+	width := 80
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		width = w
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1B[H\x1B[2J")
+	updated := "waiting for the first sample"
+	if d.ready {
+		updated = d.sample.Time.Format(time.TimeOnly)
+	}
+	fmt.Fprintf(&buf, "arcstats  %s  interval %s\r\n", updated, d.Interval)
+	if d.ready {
+		for _, p := range panels {
+			if d.hidden[p.key] {
+				continue
+			}
+			fmt.Fprintf(&buf, "\r\n%s\r\n", p.title)
+			p.draw(d, &buf, width)
+		}
+	}
+	if d.message != "" {
+		fmt.Fprintf(&buf, "\r\n%s", d.message)
+	} else {
+		fmt.Fprintf(&buf, "\r\n%s", dashboardHelp)
+	}
+	os.Stdout.Write(buf.Bytes())
+}
+
+// handleKey processes a single key press and reports whether the dashboard should quit.
+// The ticker is reset when the interval changes.
+func (d *Dashboard) handleKey(key byte, ticker *time.Ticker) bool {
+	d.message = ""
+	switch key {
+	case 'q', 0x03: // Ctrl-C
+		return true
+	case '+', '=':
+		d.Interval = nextInterval(d.Interval, true)
+		ticker.Reset(d.Interval)
+	case '-':
+		d.Interval = nextInterval(d.Interval, false)
+		ticker.Reset(d.Interval)
+	default:
+		for _, p := range panels {
+			if p.key == key {
+				d.hidden[key] = !d.hidden[key]
+			}
+		}
+	}
+	return false
+}
+
+// lastLine is a log output keeping the last line, the InfluxDB sink logs from its own goroutine
+type lastLine struct {
+	mu   sync.Mutex
+	line string
+}
+
+func (l *lastLine) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.line = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+// take returns the last line logged since the previous call, if any
+func (l *lastLine) take() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	line := l.line
+	l.line = ""
+	return line
+}
+
+// Run samples and redraws every Interval until the user quits
+func (d *Dashboard) Run() error {
+	if d.hidden == nil {
+		d.hidden = make(map[byte]bool)
+	}
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	os.Stdout.WriteString("\x1B[?25l")
+	defer os.Stdout.WriteString("\x1B[?25h\r\n")
+
+	// Log lines would scribble over the screen, the last one is shown instead of the help line
+	logs := new(lastLine)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(logs)
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case now := <-ticker.C:
+			sample, ok, err := d.Sampler.Next(now)
+			if err != nil {
+				// Shown like other log lines, e.g. while the module is reloaded
+				log.Println(err)
+			}
+			d.message = logs.take()
+			if err != nil || !ok {
+				continue
+			}
+			d.add(sample)
+			if d.Influx != nil {
+				d.Influx.Write(sample)
+			}
+		case key, ok := <-keys:
+			if !ok || d.handleKey(key, ticker) {
+				return nil
+			}
+		case <-signals:
+			return nil
+		}
+	}
+}
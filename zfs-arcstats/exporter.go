@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// exportedKstats are the kstat files exposed by serve
+var exportedKstats = []string{"arcstats", "zfetchstats", "dmu_tx", "abdstats"}
+
+// gaugeNames are kstats that go up and down but are not named like sizes
+var gaugeNames = map[string]bool{
+	"c": true, "c_min": true, "c_max": true, "p": true, "pd": true, "pm": true, "meta": true,
+	"arc_meta_used": true, "arc_meta_limit": true, "arc_meta_max": true, "arc_meta_min": true,
+	"arc_dnode_limit": true, "arc_no_grow": true, "arc_tempreserve": true, "arc_loaned_bytes": true,
+	"arc_need_free": true, "arc_sys_free": true,
+	"memory_all_bytes": true, "memory_free_bytes": true, "memory_available_bytes": true,
+	"scatter_chunk_waste": true, "cached_only_in_progress": true, "io_active": true,
+	"hash_elements": true, "hash_elements_max": true, "hash_chains": true, "hash_chain_max": true,
+	"l2_log_blk_count": true, "l2_data_to_meta_ratio": true,
+}
+
+// isGauge guesses whether a kstat is a level rather than an ever increasing counter.
+// The state sizes of OpenZFS 2.2 end in _data and _metadata, e.g. mru_data and anon_metadata.
+func isGauge(name string) bool {
+	return gaugeNames[name] || strings.HasSuffix(name, "size") ||
+		strings.HasSuffix(name, "_data") || strings.HasSuffix(name, "_metadata") ||
+		strings.Contains(name, "evictable") || strings.HasSuffix(name, "_cnt") ||
+		strings.HasPrefix(name, "scatter_order_")
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// writeMetrics writes every numeric entry of a kstat in the Prometheus text format
+func writeMetrics(buf *bytes.Buffer, kstat string, k *Kstat) {
+	for _, name := range k.Names {
+		v := k.Values[name]
+		if v.Type == KstatChar || v.Type == KstatString {
+			continue
+		}
+		metric := "zfs_" + kstat + "_" + invalidMetricChars.ReplaceAllString(strings.TrimPrefix(name, kstat+"_"), "_")
+		typ := "counter"
+		if isGauge(name) {
+			typ = "gauge"
+		} else {
+			metric += "_total"
+		}
+		fmt.Fprintf(buf, "# HELP %s %s kstat %s\n", metric, kstat, name)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", metric, typ)
+		switch v.Type {
+		case KstatInt32, KstatInt64, KstatLong:
+			fmt.Fprintf(buf, "%s %d\n", metric, v.Int)
+		default:
+			fmt.Fprintf(buf, "%s %d\n", metric, v.Uint)
+		}
+	}
+}
+
+// writeIOMetrics writes the I/O counters of pools and datasets, labelled with the pool and dataset name
+func writeIOMetrics(buf *bytes.Buffer, stats []IOStat) {
+	if len(stats) == 0 {
+		return
+	}
+	for _, c := range []struct {
+		name, help string
+		value      func(IOStat) uint64
+	}{
+		{"reads", "Read operations", func(io IOStat) uint64 { return io.Reads }},
+		{"writes", "Write operations", func(io IOStat) uint64 { return io.Writes }},
+		{"read_bytes", "Bytes read", func(io IOStat) uint64 { return io.NRead }},
+		{"written_bytes", "Bytes written", func(io IOStat) uint64 { return io.NWritten }},
+	} {
+		metric := "zfs_io_" + c.name + "_total"
+		fmt.Fprintf(buf, "# HELP %s %s of a pool, or of a dataset if the dataset label is set\n", metric, c.help)
+		fmt.Fprintf(buf, "# TYPE %s counter\n", metric)
+		for _, io := range stats {
+			fmt.Fprintf(buf, "%s{pool=%q,dataset=%q} %d\n", metric, io.Pool, io.Dataset, c.value(io))
+		}
+	}
+}
+
+// Exporter serves the kstats under Root as Prometheus metrics, reading them on every scrape
+type Exporter struct {
+	Root string
+}
+
+func (e Exporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP zfs_kstat_up Whether the kstat file could be read")
+	fmt.Fprintln(&buf, "# TYPE zfs_kstat_up gauge")
+	// Samples of zfs_kstat_up must be written together, before the metrics of each kstat
+	kstats := make(map[string]*Kstat)
+	for _, kstat := range exportedKstats {
+		k, err := ReadKstat(filepath.Join(e.Root, kstat))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Println(err)
+			}
+			fmt.Fprintf(&buf, "zfs_kstat_up{kstat=%q} 0\n", kstat)
+			continue
+		}
+		fmt.Fprintf(&buf, "zfs_kstat_up{kstat=%q} 1\n", kstat)
+		kstats[kstat] = k
+	}
+	for _, kstat := range exportedKstats {
+		if k := kstats[kstat]; k != nil {
+			writeMetrics(&buf, kstat, k)
+		}
+	}
+	if stats, err := ReadIOStats(e.Root); err != nil {
+		log.Println(err)
+	} else {
+		writeIOMetrics(&buf, stats)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// serveMain implements "arcstats serve"
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("l", ":9881", "listen `address`")
+	root := fs.String("root", DefaultKstatRoot, "kstat `directory` to read")
+	fs.Parse(args)
+
+	http.Handle("/metrics", Exporter{*root})
+	log.Printf("Serving metrics on %s/metrics\n", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sample is a pair of consecutive snapshots
+type Sample struct {
+	Cur, Prev Stat
+	Time      time.Time
+	Secs      float64 // seconds between Prev and Cur
+
+	// IO is only read with -io
+	IO     []IOStat
+	PrevIO map[string]IOStat
+}
+
+// Delta returns how much a counter grew during the sample
+func (s Sample) Delta(counter func(Stat) uint64) uint64 {
+	cur, prev := counter(s.Cur), counter(s.Prev)
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+// Rate returns the per second growth of a counter
+func (s Sample) Rate(counter func(Stat) uint64) float64 {
+	if s.Secs <= 0 {
+		return 0
+	}
+	return float64(s.Delta(counter)) / s.Secs
+}
+
+// Ratio returns the growth of part as a percentage of the growth of total
+func (s Sample) Ratio(part, total func(Stat) uint64) float64 {
+	t := s.Delta(total)
+	if t == 0 {
+		return 0
+	}
+	return float64(s.Delta(part)) / float64(t) * 100
+}
+
+const (
+	kindTime = iota
+	kindRate
+	kindGauge
+	kindPercent
+)
+
+// Field is an output column, named after the fields of OpenZFS arcstat
+type Field struct {
+	Name  string
+	Width int
+	Kind  int
+	Desc  string
+	Value func(Sample) float64
+}
+
+// Format formats the value of f right-aligned to its width
+func (f Field) Format(s Sample) string {
+	v := f.Value(s)
+	switch f.Kind {
+	case kindTime:
+		return fmt.Sprintf("%*s", f.Width, s.Time.Format(time.TimeOnly))
+	case kindPercent:
+		return fmt.Sprintf("%*.0f", f.Width, v)
+	}
+	return fmt.Sprintf("%*s", f.Width, humanize(v))
+}
+
+// humanize formats a number with binary suffixes like arcstat does, e.g. 1.5K or 12G
+func humanize(v float64) string {
+	const suffixes = " KMGTPE"
+	i := 0
+	for v >= 1024 && i < len(suffixes)-1 {
+		v /= 1024
+		i++
+	}
+	switch {
+	case i == 0:
+		return fmt.Sprintf("%.0f", v)
+	case v < 9.95: // would round to 10.0
+		return fmt.Sprintf("%.1f%c", v, suffixes[i])
+	}
+	return fmt.Sprintf("%.0f%c", v, suffixes[i])
+}
+
+func counter(name string) func(Stat) uint64 {
+	return func(s Stat) uint64 { return s.Uint(name) }
+}
+
+func sum(counters ...func(Stat) uint64) func(Stat) uint64 {
+	return func(s Stat) uint64 {
+		var total uint64
+		for _, c := range counters {
+			total += c(s)
+		}
+		return total
+	}
+}
+
+func rate(name, desc string, c func(Stat) uint64) Field {
+	return Field{name, 5, kindRate, desc, func(s Sample) float64 { return s.Rate(c) }}
+}
+
+func gauge(name, desc string, g func(Stat) uint64) Field {
+	return Field{name, 5, kindGauge, desc, func(s Sample) float64 { return float64(g(s.Cur)) }}
+}
+
+func percent(name, desc string, part, total func(Stat) uint64) Field {
+	return Field{name, 4, kindPercent, desc, func(s Sample) float64 { return s.Ratio(part, total) }}
+}
+
+var (
+	reads          = sum(Stat.Hits, Stat.Misses)
+	demandReads    = sum(Stat.DemandHits, Stat.DemandMisses)
+	prefetchReads  = sum(Stat.PrefetchHits, Stat.PrefetchMisses)
+	metadataHits   = sum(counter("demand_metadata_hits"), counter("prefetch_metadata_hits"))
+	metadataMisses = sum(counter("demand_metadata_misses"), counter("prefetch_metadata_misses"))
+	metadataReads  = sum(metadataHits, metadataMisses)
+	l2Reads        = sum(Stat.L2Hits, Stat.L2Misses)
+)
+
+var fieldList = []Field{
+	{"time", 8, kindTime, "Time", func(s Sample) float64 { return float64(s.Time.Unix()) }},
+	rate("read", "Total ARC accesses per second", reads),
+	rate("hits", "ARC hits per second", Stat.Hits),
+	rate("miss", "ARC misses per second", Stat.Misses),
+	percent("hit%", "ARC hit percentage", Stat.Hits, reads),
+	percent("miss%", "ARC miss percentage", Stat.Misses, reads),
+	rate("dread", "Demand accesses per second", demandReads),
+	rate("dhit", "Demand hits per second", Stat.DemandHits),
+	rate("dmis", "Demand misses per second", Stat.DemandMisses),
+	percent("dh%", "Demand hit percentage", Stat.DemandHits, demandReads),
+	percent("dm%", "Demand miss percentage", Stat.DemandMisses, demandReads),
+	rate("pread", "Prefetch accesses per second", prefetchReads),
+	rate("phit", "Prefetch hits per second", Stat.PrefetchHits),
+	rate("pmis", "Prefetch misses per second", Stat.PrefetchMisses),
+	percent("ph%", "Prefetch hit percentage", Stat.PrefetchHits, prefetchReads),
+	percent("pm%", "Prefetch miss percentage", Stat.PrefetchMisses, prefetchReads),
+	rate("mread", "Metadata accesses per second", metadataReads),
+	rate("mhit", "Metadata hits per second", metadataHits),
+	rate("mmis", "Metadata misses per second", metadataMisses),
+	percent("mh%", "Metadata hit percentage", metadataHits, metadataReads),
+	percent("mm%", "Metadata miss percentage", metadataMisses, metadataReads),
+	rate("mru", "MRU list hits per second", counter("mru_hits")),
+	rate("mfu", "MFU list hits per second", counter("mfu_hits")),
+	rate("mrug", "MRU ghost list hits per second", counter("mru_ghost_hits")),
+	rate("mfug", "MFU ghost list hits per second", counter("mfu_ghost_hits")),
+	rate("mtxmis", "mutex_miss per second", counter("mutex_miss")),
+	rate("eskip", "evict_skip per second", counter("evict_skip")),
+	gauge("arcsz", "ARC size", Stat.Size),
+	gauge("size", "ARC size", Stat.Size),
+	gauge("c", "ARC target size", Stat.Target),
+	gauge("cmin", "Minimum ARC target size", Stat.TargetMin),
+	gauge("cmax", "Maximum ARC target size", Stat.TargetMax),
+	gauge("mrusz", "MRU size", Stat.MRUSize),
+	gauge("mfusz", "MFU size", Stat.MFUSize),
+	gauge("meta", "Metadata size", Stat.MetadataSize),
+	gauge("free", "ARC free memory", counter("memory_free_bytes")),
+	gauge("avail", "ARC available memory", counter("memory_available_bytes")),
+	rate("l2read", "Total L2ARC accesses per second", l2Reads),
+	rate("l2hits", "L2ARC hits per second", Stat.L2Hits),
+	rate("l2miss", "L2ARC misses per second", Stat.L2Misses),
+	percent("l2hit%", "L2ARC access hit percentage", Stat.L2Hits, l2Reads),
+	percent("l2miss%", "L2ARC access miss percentage", Stat.L2Misses, l2Reads),
+	rate("l2bytes", "Bytes read per second from the L2ARC", counter("l2_read_bytes")),
+	rate("l2wbytes", "Bytes written per second to the L2ARC", counter("l2_write_bytes")),
+	gauge("l2size", "Size of the L2ARC", Stat.L2Size),
+	gauge("l2asize", "Allocated size of the L2ARC", Stat.L2ASize),
+}
+
+var fields = make(map[string]Field)
+
+func init() {
+	for i := range fieldList {
+		f := &fieldList[i]
+		if f.Width < len(f.Name) {
+			f.Width = len(f.Name)
+		}
+		fields[f.Name] = *f
+	}
+}
+
+const defaultFields = "read,hits,miss,hit%,l2hits,l2miss,l2hit%"
+
+func parseFields(s string) ([]Field, error) {
+	var out []Field
+	var unknown []string
+	for _, name := range strings.Split(s, ",") {
+		f, ok := fields[strings.TrimSpace(name)]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		out = append(out, f)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown fields: %s (see -v)", strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
+
+func listFields() {
+	fmt.Fprintln(os.Stderr, "Fields:")
+	for _, f := range fieldList {
+		fmt.Fprintf(os.Stderr, "%11s : %s\n", f.Name, f.Desc)
+	}
+}
+
+func formatHeader(fs []Field) string {
+	parts := make([]string, len(fs))
+	for i, f := range fs {
+		parts[i] = fmt.Sprintf("%*s", f.Width, f.Name)
+	}
+	return strings.Join(parts, "  ")
+}
+
+func formatLine(fs []Field, s Sample) string {
+	parts := make([]string, len(fs))
+	for i, f := range fs {
+		parts[i] = f.Format(s)
+	}
+	return strings.Join(parts, "  ")
+}
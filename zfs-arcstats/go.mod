@@ -1,3 +1,19 @@
 module arcstats
 
 go 1.20
+
+require (
+	github.com/influxdata/influxdb-client-go/v2 v2.12.3
+	golang.org/x/term v0.21.0
+	sigs.k8s.io/yaml v1.4.0
+)
+
+require (
+	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
+	github.com/deepmap/oapi-codegen v1.12.4 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/influxdata/line-protocol v0.0.0-20210922203350-b1ad95c89adf // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"sigs.k8s.io/yaml"
+)
+
+type InfluxDBConfig struct {
+	Host     string `json:"host"`
+	Token    string `json:"token"`
+	Database string `json:"database"`
+}
+
+type Config struct {
+	InfluxDB *InfluxDBConfig `json:"influxdb"`
+}
+
+// loadConfig reads a JSON or YAML config file
+func loadConfig(filename string) *Config {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+	config := new(Config)
+	err = yaml.Unmarshal(b, &config)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// InfluxSink writes every sample as an arcstats point. The write API batches
+// points and sends them in the background, so a slow database never blocks sampling.
+type InfluxSink struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPI
+	hostname string
+}
+
+func NewInfluxSink(config InfluxDBConfig) *InfluxSink {
+	hostname, _ := os.Hostname()
+	client := influxdb2.NewClient(config.Host, config.Token)
+	writeAPI := client.WriteAPI("", config.Database)
+	go func() {
+		for err := range writeAPI.Errors() {
+			log.Println("InfluxDB write error:", err)
+		}
+	}()
+	return &InfluxSink{client, writeAPI, hostname}
+}
+
+// arcstatsPoint has every numeric arcstats value plus the deltas and ratios of the
+// machine-readable outputs as fields
+func arcstatsPoint(sample Sample, hostname string) *write.Point {
+	p := influxdb2.NewPointWithMeasurement("arcstats").
+		AddTag("host", hostname).
+		SetTime(sample.Time)
+	k := sample.Cur.Kstat
+	for _, name := range k.Names {
+		switch v := k.Values[name]; v.Type {
+		case KstatChar, KstatString:
+		case KstatInt32, KstatInt64, KstatLong:
+			p.AddField(name, v.Int)
+		default:
+			p.AddField(name, v.Uint)
+		}
+	}
+	for _, f := range record(sample) {
+		if f.name == "time" || k.Has(f.name) {
+			// Already written as is
+			continue
+		}
+		p.AddField(f.name, f.value)
+	}
+	return p
+}
+
+// Write sends an arcstats point, and a zfs_io point per pool and dataset if I/O statistics are read
+func (s *InfluxSink) Write(sample Sample) {
+	s.writeAPI.WritePoint(arcstatsPoint(sample, s.hostname))
+
+	for _, r := range ioRecords(sample) {
+		p := influxdb2.NewPointWithMeasurement("zfs_io").
+			AddTag("host", s.hostname).
+			AddTag("pool", r.Pool).
+			AddField("reads", r.Reads).
+			AddField("writes", r.Writes).
+			AddField("nread", r.NRead).
+			AddField("nwritten", r.NWritten).
+			AddField("reads_delta", r.ReadsDelta).
+			AddField("writes_delta", r.WritesDelta).
+			AddField("nread_delta", r.NReadDelta).
+			AddField("nwritten_delta", r.NWrittenDelta).
+			SetTime(sample.Time)
+		if r.Dataset != "" {
+			p.AddTag("dataset", r.Dataset)
+		}
+		s.writeAPI.WritePoint(p)
+	}
+}
+
+// Close flushes pending points
+func (s *InfluxSink) Close() {
+	s.writeAPI.Flush()
+	s.client.Close()
+}
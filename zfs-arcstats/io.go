@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IOStat holds the I/O counters of a pool (from <pool>/io) or a dataset (from <pool>/objset-*)
+type IOStat struct {
+	ID      string `json:"id"` // pool, or pool/objset-0x36
+	Pool    string `json:"pool"`
+	Dataset string `json:"dataset"` // empty for pools
+
+	Reads    uint64 `json:"reads"`
+	Writes   uint64 `json:"writes"`
+	NRead    uint64 `json:"nread"`
+	NWritten uint64 `json:"nwritten"`
+
+	crtime uint64 // changes when the kstat is recreated, e.g. on pool import
+}
+
+// Name returns the dataset name, or the pool name for pool totals
+func (io IOStat) Name() string {
+	if io.Dataset != "" {
+		return io.Dataset
+	}
+	return io.Pool
+}
+
+// ReadIOStats reads the I/O kstats of every pool and dataset under root, pools first.
+// Pools without an io kstat (OpenZFS 2.1 and later) only have their datasets listed.
+func ReadIOStats(root string) ([]IOStat, error) {
+	var stats []IOStat
+	files, err := filepath.Glob(filepath.Join(root, "*", "io"))
+	if err != nil {
+		return nil, err
+	}
+	objsets, err := filepath.Glob(filepath.Join(root, "*", "objset-*"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, objsets...)
+	for _, filename := range files {
+		k, err := ReadKstat(filename)
+		if os.IsNotExist(err) {
+			// The dataset was unmounted or the pool exported since the glob
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pool := filepath.Base(filepath.Dir(filename))
+		io := IOStat{
+			ID:       pool,
+			Pool:     pool,
+			Reads:    k.Uint("reads"),
+			Writes:   k.Uint("writes"),
+			NRead:    k.Uint("nread"),
+			NWritten: k.Uint("nwritten"),
+			crtime:   k.Crtime,
+		}
+		if base := filepath.Base(filename); base != "io" {
+			io.ID = pool + "/" + base
+			io.Dataset = k.String("dataset_name")
+		}
+		stats = append(stats, io)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Pool != b.Pool {
+			return a.Pool < b.Pool
+		}
+		if (a.Dataset == "") != (b.Dataset == "") {
+			return a.Dataset == ""
+		}
+		return a.Dataset < b.Dataset
+	})
+	return stats, nil
+}
+
+// IODelta returns the counters of io accumulated during the sample, zero if it is new or was recreated
+func (s Sample) IODelta(io IOStat) IOStat {
+	prev, ok := s.PrevIO[io.ID]
+	if !ok || prev.crtime != io.crtime {
+		return IOStat{ID: io.ID, Pool: io.Pool, Dataset: io.Dataset}
+	}
+	delta := io
+	for _, c := range []struct{ cur, prev *uint64 }{
+		{&delta.Reads, &prev.Reads},
+		{&delta.Writes, &prev.Writes},
+		{&delta.NRead, &prev.NRead},
+		{&delta.NWritten, &prev.NWritten},
+	} {
+		if *c.cur < *c.prev {
+			*c.cur = 0
+		} else {
+			*c.cur -= *c.prev
+		}
+	}
+	return delta
+}
+
+func ioMap(stats []IOStat) map[string]IOStat {
+	m := make(map[string]IOStat, len(stats))
+	for _, io := range stats {
+		m[io.ID] = io
+	}
+	return m
+}
+
+// formatIO formats a header and a line of per second rates for every pool and dataset
+func formatIO(s Sample) []string {
+	if len(s.IO) == 0 {
+		return nil
+	}
+	width := len("pool/dataset")
+	for _, io := range s.IO {
+		if n := len(io.Name()); n > width {
+			width = n
+		}
+	}
+	lines := []string{fmt.Sprintf("  %-*s  %6s  %6s  %6s  %6s", width, "pool/dataset", "r/s", "w/s", "rbw/s", "wbw/s")}
+	perSec := func(v uint64) string {
+		if s.Secs <= 0 {
+			return "0"
+		}
+		return humanize(float64(v) / s.Secs)
+	}
+	for _, io := range s.IO {
+		d := s.IODelta(io)
+		name := io.Name()
+		if child, ok := strings.CutPrefix(name, io.Pool+"/"); ok {
+			// Indent child datasets below their pool
+			name = "  " + child
+		}
+		lines = append(lines, fmt.Sprintf("  %-*s  %6s  %6s  %6s  %6s",
+			width, name, perSec(d.Reads), perSec(d.Writes), perSec(d.NRead), perSec(d.NWritten)))
+	}
+	return lines
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Data types of named kstats, from the type column
+const (
+	KstatChar = iota
+	KstatInt32
+	KstatUint32
+	KstatInt64
+	KstatUint64
+	KstatLong
+	KstatUlong
+	KstatString
+)
+
+// Kstat types from the header, only named and I/O kstats are parsed
+const (
+	KstatTypeNamed = 1
+	KstatTypeIO    = 3
+)
+
+type KstatValue struct {
+	Type int
+	Int  int64  // value of signed types, and of unsigned types that fit
+	Uint uint64 // value of unsigned types, and of signed types that are not negative
+	Str  string // value of char and string types
+}
+
+// Kstat is a named kstat file like /proc/spl/kstat/zfs/arcstats, or an I/O kstat like
+// /proc/spl/kstat/zfs/<pool>/io with its columns stored as uint64 values
+type Kstat struct {
+	Type int
+
+	// Crtime and Snaptime are the creation and last update times in nanoseconds of a monotonic clock
+	Crtime, Snaptime uint64
+
+	Names  []string // in file order
+	Values map[string]KstatValue
+}
+
+func parseKstatValue(typ int, s string) (v KstatValue, err error) {
+	v.Type = typ
+	switch typ {
+	case KstatInt32, KstatInt64, KstatLong:
+		if v.Int, err = strconv.ParseInt(s, 10, 64); err == nil && v.Int >= 0 {
+			v.Uint = uint64(v.Int)
+		}
+	case KstatUint32, KstatUint64, KstatUlong:
+		if v.Uint, err = strconv.ParseUint(s, 10, 64); err == nil && v.Uint <= 1<<63-1 {
+			v.Int = int64(v.Uint)
+		}
+	case KstatChar, KstatString:
+		v.Str = s
+	default:
+		err = fmt.Errorf("unknown kstat type %d", typ)
+	}
+	return
+}
+
+// ParseKstat reads the header, the column line and every named value of a kstat file
+func ParseKstat(r io.Reader) (*Kstat, error) {
+	k := &Kstat{Values: make(map[string]KstatValue)}
+	scanner := bufio.NewScanner(r)
+
+	// kid type flags ndata data_size crtime snaptime
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("missing kstat header")
+	}
+	header := strings.Fields(scanner.Text())
+	if len(header) != 7 {
+		return nil, fmt.Errorf("invalid kstat header: %q", scanner.Text())
+	}
+	var err error
+	if k.Type, err = strconv.Atoi(header[1]); err != nil {
+		return nil, err
+	}
+	if k.Crtime, err = strconv.ParseUint(header[5], 10, 64); err != nil {
+		return nil, err
+	}
+	if k.Snaptime, err = strconv.ParseUint(header[6], 10, 64); err != nil {
+		return nil, err
+	}
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("missing kstat column line")
+	}
+	columns := strings.Fields(scanner.Text())
+	switch k.Type {
+	case KstatTypeIO:
+		if err := parseIOKstat(k, columns, scanner); err != nil {
+			return nil, err
+		}
+		return k, nil
+	case KstatTypeNamed:
+		if len(columns) != 3 {
+			return nil, fmt.Errorf("invalid kstat column line")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kstat type %d", k.Type)
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid kstat line: %q", scanner.Text())
+		}
+		typ, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid kstat type of %s: %s", fields[0], fields[1])
+		}
+		// Strings may be empty or contain spaces
+		v, err := parseKstatValue(typ, strings.Join(fields[2:], " "))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fields[0], err)
+		}
+		if _, ok := k.Values[fields[0]]; !ok {
+			k.Names = append(k.Names, fields[0])
+		}
+		k.Values[fields[0]] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// parseIOKstat reads the single line of values of an I/O kstat
+func parseIOKstat(k *Kstat, columns []string, scanner *bufio.Scanner) error {
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("missing I/O kstat values")
+	}
+	values := strings.Fields(scanner.Text())
+	if len(values) != len(columns) {
+		return fmt.Errorf("I/O kstat has %d columns but %d values", len(columns), len(values))
+	}
+	for i, name := range columns {
+		v, err := parseKstatValue(KstatUint64, values[i])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		k.Names = append(k.Names, name)
+		k.Values[name] = v
+	}
+	return nil
+}
+
+func ReadKstat(filename string) (*Kstat, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKstat(f)
+}
+
+// Uint returns an unsigned value, 0 if it does not exist
+func (k *Kstat) Uint(name string) uint64 {
+	return k.Values[name].Uint
+}
+
+// Int returns a signed value, 0 if it does not exist
+func (k *Kstat) Int(name string) int64 {
+	return k.Values[name].Int
+}
+
+// String returns a string value, "" if it does not exist
+func (k *Kstat) String(name string) string {
+	return k.Values[name].Str
+}
+
+func (k *Kstat) Has(name string) bool {
+	_, ok := k.Values[name]
+	return ok
+}
@@ -1,93 +1,203 @@
 package main
 
 import (
-	"bufio"
+	"encoding/csv"
 	"flag"
 	"fmt"
+	"log"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
-const SourcePath = "/proc/spl/kstat/zfs/arcstats"
+// DefaultKstatRoot is where the ZFS module publishes its kstats, e.g. /host/proc/spl/kstat/zfs
+// may be used instead to read the host's kstats from a container
+const DefaultKstatRoot = "/proc/spl/kstat/zfs"
 
+// Stat is a snapshot of arcstats with accessors for commonly used values
 type Stat struct {
-	Hits, Misses     uint64
-	L2Hits, L2Misses uint64
+	*Kstat
 }
 
-func GetStats() (Stat, error) {
-	f, err := os.Open(SourcePath)
+// GetStats reads the arcstats kstat under root
+func GetStats(root string) (Stat, error) {
+	k, err := ReadKstat(filepath.Join(root, "arcstats"))
 	if err != nil {
 		return Stat{}, err
 	}
-	defer f.Close()
-	var s Stat
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if len(fields) != 3 {
-			continue
-		}
-		switch fields[0] {
-		case "hits":
-			s.Hits, err = strconv.ParseUint(fields[2], 10, 64)
-		case "misses":
-			s.Misses, err = strconv.ParseUint(fields[2], 10, 64)
-		case "l2_hits":
-			s.L2Hits, err = strconv.ParseUint(fields[2], 10, 64)
-		case "l2_misses":
-			s.L2Misses, err = strconv.ParseUint(fields[2], 10, 64)
-		}
-		if err != nil {
-			return Stat{}, err
-		}
+	return Stat{k}, nil
+}
+
+func (s Stat) Hits() uint64     { return s.Uint("hits") }
+func (s Stat) Misses() uint64   { return s.Uint("misses") }
+func (s Stat) L2Hits() uint64   { return s.Uint("l2_hits") }
+func (s Stat) L2Misses() uint64 { return s.Uint("l2_misses") }
+
+// Size is the current ARC size in bytes
+func (s Stat) Size() uint64 { return s.Uint("size") }
+
+// Target is the ARC target size (c) and its bounds in bytes
+func (s Stat) Target() uint64    { return s.Uint("c") }
+func (s Stat) TargetMin() uint64 { return s.Uint("c_min") }
+func (s Stat) TargetMax() uint64 { return s.Uint("c_max") }
+
+func (s Stat) MRUSize() uint64 { return s.Uint("mru_size") }
+func (s Stat) MFUSize() uint64 { return s.Uint("mfu_size") }
+
+// MetadataSize is metadata_size, or arc_meta_used before OpenZFS 2.2
+func (s Stat) MetadataSize() uint64 {
+	if s.Has("metadata_size") {
+		return s.Uint("metadata_size")
+	}
+	return s.Uint("arc_meta_used")
+}
+
+func (s Stat) DemandHits() uint64 {
+	return s.Uint("demand_data_hits") + s.Uint("demand_metadata_hits")
+}
+
+func (s Stat) DemandMisses() uint64 {
+	return s.Uint("demand_data_misses") + s.Uint("demand_metadata_misses")
+}
+
+func (s Stat) PrefetchHits() uint64 {
+	return s.Uint("prefetch_data_hits") + s.Uint("prefetch_metadata_hits")
+}
+
+func (s Stat) PrefetchMisses() uint64 {
+	return s.Uint("prefetch_data_misses") + s.Uint("prefetch_metadata_misses")
+}
+
+// L2Size is the logical size of L2ARC data, L2ASize the space allocated on cache devices
+func (s Stat) L2Size() uint64  { return s.Uint("l2_size") }
+func (s Stat) L2ASize() uint64 { return s.Uint("l2_asize") }
+
+// L2Compression is the L2ARC compression ratio, 0 if L2ARC is empty
+func (s Stat) L2Compression() float64 {
+	if s.L2ASize() == 0 {
+		return 0
 	}
-	return s, nil
+	return float64(s.L2Size()) / float64(s.L2ASize())
 }
 
 func main() {
-	intervalP := flag.Duration("i", time.Second, "interval")
-	flag.Parse()
-	interval := intervalP.Seconds()
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serveMain(os.Args[2:])
+		return
+	}
 
-	last, err := GetStats()
+	var (
+		interval    time.Duration
+		fieldNames  string
+		format      string
+		configFile  string
+		list        bool
+		scroll      bool
+		headerEvery int
+		count       int
+		readIO      bool
+		dashboard   bool
+		root        string
+	)
+	flag.DurationVar(&interval, "i", time.Second, "interval")
+	flag.StringVar(&fieldNames, "f", defaultFields, "comma-separated `fields` to show")
+	flag.BoolVar(&list, "v", false, "list available fields")
+	flag.StringVar(&format, "o", "text", "output `format`: text, json, csv or none (json and csv ignore -f)")
+	flag.StringVar(&configFile, "config", "", "JSON or YAML config `file` with an influxdb block to push samples to (optional)")
+	flag.BoolVar(&scroll, "s", false, "print one line per interval (default when stdout is not a terminal)")
+	flag.IntVar(&headerEvery, "H", 20, "repeat the header every `N` lines in scrolling mode, 0 for once")
+	flag.IntVar(&count, "c", 0, "exit after `N` samples, 0 for no limit")
+	flag.BoolVar(&readIO, "io", false, "also show per-pool and per-dataset I/O (not in csv output)")
+	flag.BoolVar(&dashboard, "t", false, "show an interactive full-screen dashboard instead of columns")
+	flag.StringVar(&root, "root", DefaultKstatRoot, "kstat `directory` to read")
+	flag.Parse()
+	if list {
+		listFields()
+		return
+	}
+	fs, err := parseFields(fieldNames)
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if dashboard && !isTerminal(os.Stdout) {
+		fmt.Fprintln(os.Stderr, "the dashboard needs a terminal")
+		os.Exit(2)
+	}
+
+	var out Output
+	switch {
+	case dashboard:
+		out = nopOutput{}
+	case format == "json":
+		out = &jsonOutput{w: os.Stdout}
+	case format == "csv":
+		out = &csvOutput{w: csv.NewWriter(os.Stdout)}
+	case format == "none":
+		out = nopOutput{}
+	case format != "text":
+		fmt.Fprintf(os.Stderr, "unknown output format: %s\n", format)
+		os.Exit(2)
+	case scroll || !isTerminal(os.Stdout):
+		out = &scrollOutput{w: os.Stdout, fields: withTime(fs), HeaderEvery: headerEvery}
+	default:
+		out = &redrawOutput{w: os.Stdout, fields: fs}
+	}
+	defer out.Close()
+
+	var influx *InfluxSink
+	if configFile != "" {
+		if config := loadConfig(configFile); config.InfluxDB != nil {
+			influx = NewInfluxSink(*config.InfluxDB)
+			defer influx.Close()
+		}
+	}
+
+	sampler := Sampler{Root: root, ReadIO: readIO}
+	if err := sampler.Init(); err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("%8s  %8s  %5s  %5s%%  %7s  %6s  %5s%%\n",
-		"req/s", "ARC Hit", "Miss", "Rate", "L2 Hit", "Miss", "Rate")
-	defer fmt.Println()
+	if dashboard {
+		d := Dashboard{Sampler: &sampler, Interval: interval, Influx: influx}
+		if err := d.Run(); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	ticker := time.NewTicker(*intervalP)
+	// Stop on signals so that deferred calls flush the output and pending points
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	for range ticker.C {
-		s, err := GetStats()
+	for n := 0; count == 0 || n < count; {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-signals:
+			return
+		}
+		sample, ok, err := sampler.Next(now)
 		if err != nil {
-			panic(err)
+			// arcstats disappears for a moment while the module is reloaded, the next
+			// successful read fails Sample.Reset and becomes the new baseline
+			log.Println(err)
+			continue
 		}
-
-		hits := s.Hits - last.Hits
-		misses := s.Misses - last.Misses
-		l2hits := s.L2Hits - last.L2Hits
-		l2misses := s.L2Misses - last.L2Misses
-
-		hitrate := float64(hits) / float64(interval)
-		missrate := float64(misses) / float64(interval)
-		l2hitrate := float64(l2hits) / float64(interval)
-		l2missrate := float64(l2misses) / float64(interval)
-		reqrate := float64(hits+misses) / float64(interval)
-		hitratio, l2hitratio := 0.0, 0.0
-		if hits+misses > 0 {
-			hitratio = float64(hits) / float64(hits+misses) * 100.0
+		if !ok {
+			continue
+		}
+		n++
+		if err := out.Write(sample); err != nil {
+			// e.g. the reading end of a pipe went away
+			return
 		}
-		if l2hits+l2misses > 0 {
-			l2hitratio = float64(l2hits) / float64(l2hits+l2misses) * 100.0
+		if influx != nil {
+			influx.Write(sample)
 		}
-		fmt.Printf("\r\x1B[2K%8.1f  %8.1f  %5.1f  %5.1f%%  %7.1f  %6.1f  %5.1f%%",
-			reqrate, hitrate, missrate, hitratio, l2hitrate, l2missrate, l2hitratio)
-		last = s
 	}
 }
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRoot = "testdata/zfs"
+
+func readStat(t *testing.T, filename string) Stat {
+	t.Helper()
+	k, err := ReadKstat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Stat{k}
+}
+
+func TestParseKstat(t *testing.T) {
+	s, err := GetStats(testRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != KstatTypeNamed || s.Crtime != 5247521574 || s.Snaptime != 1765484729512345 {
+		t.Errorf("header: type %d crtime %d snaptime %d", s.Type, s.Crtime, s.Snaptime)
+	}
+	if len(s.Names) != 75 || s.Names[0] != "hits" || s.Names[len(s.Names)-1] != "abd_chunk_waste_size" {
+		t.Errorf("got %d names: %v", len(s.Names), s.Names)
+	}
+	for name, want := range map[string]uint64{
+		"hits":                   48213377,
+		"misses":                 1525131,
+		"c":                      16106127360,
+		"size":                   15998934528,
+		"memory_available_bytes": 5987654321,
+		"missing":                0,
+	} {
+		if got := s.Uint(name); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+	if v := s.Values["memory_available_bytes"]; v.Type != KstatInt64 || v.Int != 5987654321 {
+		t.Errorf("memory_available_bytes = %+v", v)
+	}
+	if s.Has("missing") || !s.Has("arc_no_grow") {
+		t.Errorf("Has is wrong")
+	}
+
+	// Negative values of signed kstats are not representable as uint64
+	reloaded := readStat(t, "testdata/arcstats.reloaded")
+	if v := reloaded.Values["memory_available_bytes"]; v.Int != -123456789 || v.Uint != 0 {
+		t.Errorf("negative memory_available_bytes = %+v", v)
+	}
+}
+
+func TestParseKstatIO(t *testing.T) {
+	k, err := ReadKstat(filepath.Join(testRoot, "tank", "io"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Type != KstatTypeIO || len(k.Names) != 12 {
+		t.Fatalf("type %d, names %v", k.Type, k.Names)
+	}
+	if k.Uint("nread") != 1073741824 || k.Uint("writes") != 234567 || k.Uint("rcnt") != 0 {
+		t.Errorf("values: %+v", k.Values)
+	}
+
+	objset, err := ReadKstat(filepath.Join(testRoot, "tank", "objset-0x104"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objset.String("dataset_name") != "tank/home" || objset.Uint("nread") != 983040000 {
+		t.Errorf("objset: %+v", objset.Values)
+	}
+}
+
+func TestParseKstatMalformed(t *testing.T) {
+	header := "13 1 0x01 1 16 100 200\nname type data\n"
+	for name, s := range map[string]string{
+		"empty":          "",
+		"short header":   "13 1 0x01\n",
+		"bad crtime":     "13 1 0x01 1 16 x 200\nname type data\n",
+		"no columns":     "13 1 0x01 1 16 100 200\n",
+		"unknown kstat":  "13 2 0x01 1 16 100 200\nname type data\n",
+		"bad columns":    "13 1 0x01 1 16 100 200\nname data\n",
+		"missing value":  header + "hits\n",
+		"bad type":       header + "hits x 1\n",
+		"unknown type":   header + "hits 9 1\n",
+		"bad uint":       header + "hits 4 -1\n",
+		"bad int":        header + "hits 3 x\n",
+		"io no values":   "35 3 0x00 1 80 100 200\nnread nwritten\n",
+		"io short":       "35 3 0x00 1 80 100 200\nnread nwritten\n1\n",
+		"io not numeric": "35 3 0x00 1 80 100 200\nnread nwritten\n1 x\n",
+	} {
+		if _, err := ParseKstat(strings.NewReader(s)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestStatAccessors(t *testing.T) {
+	s := readStat(t, filepath.Join(testRoot, "arcstats"))
+	if got := s.DemandHits(); got != 21123455+25123901 {
+		t.Errorf("DemandHits = %d", got)
+	}
+	if got := s.PrefetchMisses(); got != 712345+41519 {
+		t.Errorf("PrefetchMisses = %d", got)
+	}
+	if got := s.MetadataSize(); got != 1234567890 {
+		t.Errorf("MetadataSize = %d", got)
+	}
+	if got := s.L2Compression(); math.Abs(got-187654321098.0/98765432109.0) > 1e-9 {
+		t.Errorf("L2Compression = %f", got)
+	}
+	if got := s.TargetMax(); got != 32212254720 {
+		t.Errorf("TargetMax = %d", got)
+	}
+
+	// Before OpenZFS 2.2 metadata was only accounted in arc_meta_used
+	delete(s.Values, "metadata_size")
+	if got := s.MetadataSize(); got != 2912345678 {
+		t.Errorf("MetadataSize without metadata_size = %d", got)
+	}
+}
+
+func TestSampleDelta(t *testing.T) {
+	prev := readStat(t, filepath.Join(testRoot, "arcstats"))
+	cur := readStat(t, "testdata/arcstats.next")
+	s := Sample{Cur: cur, Prev: prev, Secs: 5}
+
+	if got := s.Delta(Stat.Hits); got != 50000 {
+		t.Errorf("hits delta = %d", got)
+	}
+	if got := s.Rate(Stat.Hits); got != 10000 {
+		t.Errorf("hits rate = %f", got)
+	}
+	if got := s.Ratio(Stat.Hits, reads); math.Abs(got-50000.0/51000*100) > 1e-9 {
+		t.Errorf("hit ratio = %f", got)
+	}
+	if got := s.Ratio(Stat.DemandHits, demandReads); math.Abs(got-45000.0/45700*100) > 1e-9 {
+		t.Errorf("demand hit ratio = %f", got)
+	}
+	// Nothing happened, so there is no ratio rather than a division by zero
+	if got := s.Ratio(counter("uncached_hits"), counter("uncached_hits")); got != 0 {
+		t.Errorf("empty ratio = %f", got)
+	}
+
+	if got := (Sample{Cur: cur, Prev: prev}).Rate(Stat.Hits); got != 0 {
+		t.Errorf("rate without interval = %f", got)
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	prev := readStat(t, "testdata/arcstats.next")
+	cur := readStat(t, "testdata/arcstats.reloaded")
+	s := Sample{Cur: cur, Prev: prev, Secs: 1}
+
+	for _, c := range []func(Stat) uint64{Stat.Hits, Stat.Misses, Stat.DemandHits, metadataHits, Stat.L2Misses} {
+		if got := s.Delta(c); got != 0 {
+			t.Errorf("delta after reset = %d", got)
+		}
+	}
+	if got := s.Rate(reads); got != 0 {
+		t.Errorf("read rate after reset = %f", got)
+	}
+	if got := s.Ratio(Stat.Hits, reads); got != 0 {
+		t.Errorf("hit ratio after reset = %f", got)
+	}
+	// Gauges are shown as they are
+	if got := fields["arcsz"].Value(s); got != 523456789 {
+		t.Errorf("arcsz after reset = %f", got)
+	}
+}
+
+// copyFile copies a fixture into a temporary kstat root
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	root := t.TempDir()
+	arcstats := filepath.Join(root, "arcstats")
+	copyFile(t, filepath.Join(testRoot, "arcstats"), arcstats)
+	sampler := Sampler{Root: root}
+	start := time.Now()
+	if err := sampler.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interval comes from snaptime, not from the clock
+	copyFile(t, "testdata/arcstats.next", arcstats)
+	s, ok, err := sampler.Next(start.Add(time.Second))
+	if err != nil || !ok {
+		t.Fatalf("ok %v, err %v", ok, err)
+	}
+	if s.Secs != 5.0002 {
+		t.Errorf("interval = %f, want 5.0002", s.Secs)
+	}
+	if got := s.Rate(Stat.Hits); math.Abs(got-50000/5.0002) > 1e-6 {
+		t.Errorf("hits rate = %f", got)
+	}
+
+	// arcstats is briefly missing while the module is reloaded
+	if err := os.Remove(arcstats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := sampler.Next(start.Add(1500 * time.Millisecond)); err == nil || ok {
+		t.Fatalf("without arcstats: ok %v, err %v", ok, err)
+	}
+
+	// A reloaded module is not compared with the previous one but becomes the new baseline
+	copyFile(t, "testdata/arcstats.reloaded", arcstats)
+	if _, ok, err := sampler.Next(start.Add(2 * time.Second)); err != nil || ok {
+		t.Fatalf("after reload: ok %v, err %v", ok, err)
+	}
+	s, ok, err = sampler.Next(start.Add(3 * time.Second))
+	if err != nil || !ok {
+		t.Fatalf("ok %v, err %v", ok, err)
+	}
+	// Unchanged snaptime falls back to the clock
+	if s.Secs != 1 || s.Delta(Stat.Hits) != 0 {
+		t.Errorf("interval %f, hits delta %d", s.Secs, s.Delta(Stat.Hits))
+	}
+
+	if _, _, err := (&Sampler{Root: t.TempDir()}).Next(start); err == nil {
+		t.Error("expected error without arcstats")
+	}
+}
+
+func TestSampleReset(t *testing.T) {
+	first := readStat(t, filepath.Join(testRoot, "arcstats"))
+	next := readStat(t, "testdata/arcstats.next")
+	reloaded := readStat(t, "testdata/arcstats.reloaded")
+	if (Sample{Cur: next, Prev: first}).Reset() {
+		t.Error("reset between consecutive snapshots")
+	}
+	if !(Sample{Cur: reloaded, Prev: next}).Reset() {
+		t.Error("reload not detected")
+	}
+	if !(Sample{Cur: first, Prev: next}).Reset() {
+		t.Error("counters going backwards not detected")
+	}
+}
+
+func TestReadIOStats(t *testing.T) {
+	stats, err := ReadIOStats(testRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IOStat{
+		{"tank", "tank", "", 123456, 234567, 1073741824, 2147483648, 5789012345},
+		{"tank/objset-0x36", "tank", "tank", 2000, 1000, 8192000, 4096000, 5789012399},
+		{"tank/objset-0x104", "tank", "tank/home", 120000, 50000, 983040000, 409600000, 5789012400},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %+v", stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("got %+v, want %+v", stats[i], want[i])
+		}
+	}
+
+	next := append([]IOStat(nil), stats...)
+	next[2].Reads += 500
+	next[2].NRead += 1 << 20
+	next[1].Writes = 0 // e.g. the dataset was recreated
+	s := Sample{IO: next, PrevIO: ioMap(stats[1:])}
+	if d := s.IODelta(next[0]); d.Reads != 0 || d.NWritten != 0 {
+		t.Errorf("delta of new pool = %+v", d)
+	}
+	if d := s.IODelta(next[1]); d.Writes != 0 {
+		t.Errorf("delta after reset = %+v", d)
+	}
+	if d := s.IODelta(next[2]); d.Reads != 500 || d.NRead != 1<<20 || d.Writes != 0 {
+		t.Errorf("delta = %+v", d)
+	}
+
+	// Counters of a recreated kstat start over
+	next[2].crtime++
+	if d := s.IODelta(next[2]); d.Reads != 0 || d.NRead != 0 {
+		t.Errorf("delta after recreation = %+v", d)
+	}
+}
+
+func TestReadIOStatsVanished(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "tank"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"io", "objset-0x36", "objset-0x104"} {
+		copyFile(t, filepath.Join(testRoot, "tank", name), filepath.Join(root, "tank", name))
+	}
+	// Matched by the glob but gone when read, like a dataset unmounted in between
+	if err := os.Symlink("missing", filepath.Join(root, "tank", "objset-0x200")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ReadIOStats(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestFields(t *testing.T) {
+	if _, err := parseFields(defaultFields); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseFields("hits,nope"); err == nil {
+		t.Error("expected error for unknown field")
+	}
+
+	fs, err := parseFields("time,read,hit%,arcsz,c,l2size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Sample{
+		Cur:  readStat(t, "testdata/arcstats.next"),
+		Prev: readStat(t, filepath.Join(testRoot, "arcstats")),
+		Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Secs: 5,
+	}
+	if got, want := formatHeader(fs), "    time   read  hit%  arcsz      c  l2size"; got != want {
+		t.Errorf("header = %q, want %q", got, want)
+	}
+	if got, want := formatLine(fs, s), "03:04:05    10K    98    15G    15G    175G"; got != want {
+		t.Errorf("line = %q, want %q", got, want)
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	for v, want := range map[float64]string{
+		0:           "0",
+		1023:        "1023",
+		1024:        "1.0K",
+		1536:        "1.5K",
+		10 * 1024:   "10K",
+		5 << 30:     "5.0G",
+		1 << 62:     "4.0E",
+		1023.6 * 10: "10K",
+	} {
+		if got := humanize(v); got != want {
+			t.Errorf("humanize(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := Sample{
+		Cur:  readStat(t, "testdata/arcstats.next"),
+		Prev: readStat(t, filepath.Join(testRoot, "arcstats")),
+		Secs: 5,
+	}
+	seen := make(map[string]bool)
+	values := make(map[string]any)
+	for _, f := range record(s) {
+		if seen[f.name] {
+			t.Errorf("duplicate field %s", f.name)
+		}
+		seen[f.name] = true
+		values[f.name] = f.value
+	}
+	for name, want := range map[string]any{
+		"hits":       uint64(48263377),
+		"hits_delta": uint64(50000),
+		"size":       uint64(16001034528),
+		"hit_ratio":  50000.0 / 51000,
+	} {
+		if values[name] != want {
+			t.Errorf("%s = %v, want %v", name, values[name], want)
+		}
+	}
+	if seen["size_delta"] {
+		t.Error("gauges must not have deltas")
+	}
+}
+
+func TestArcstatsPoint(t *testing.T) {
+	s := Sample{
+		Cur:  readStat(t, "testdata/arcstats.next"),
+		Prev: readStat(t, filepath.Join(testRoot, "arcstats")),
+		Secs: 5,
+	}
+	fields := make(map[string]any)
+	for _, f := range arcstatsPoint(s, "host").FieldList() {
+		if _, ok := fields[f.Key]; ok {
+			t.Errorf("duplicate field %s", f.Key)
+		}
+		fields[f.Key] = f.Value
+	}
+	for name, want := range map[string]any{
+		"hits":                   uint64(48263377),
+		"memory_available_bytes": int64(5986654321),
+		"hits_delta":             uint64(50000),
+	} {
+		if got := fields[name]; got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	for _, name := range []string{"hash_elements", "metadata_hits", "hit_ratio", "l2_compression"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("missing field %s", name)
+		}
+	}
+	if len(fields) < len(s.Cur.Names) {
+		t.Errorf("got %d fields for %d kstats", len(fields), len(s.Cur.Names))
+	}
+}
+
+func TestExporter(t *testing.T) {
+	w := httptest.NewRecorder()
+	Exporter{testRoot}.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, _ := io.ReadAll(w.Result().Body)
+	body := string(b)
+	for _, line := range []string{
+		`zfs_kstat_up{kstat="arcstats"} 1`,
+		`zfs_kstat_up{kstat="dmu_tx"} 0`,
+		"# TYPE zfs_arcstats_hits_total counter",
+		"zfs_arcstats_hits_total 48213377",
+		"# TYPE zfs_arcstats_size gauge",
+		"zfs_arcstats_size 15998934528",
+		"# TYPE zfs_arcstats_c_max gauge",
+		"zfs_arcstats_memory_available_bytes 5987654321",
+		"zfs_zfetchstats_hits_total 1234567",
+		"# TYPE zfs_arcstats_hash_elements gauge",
+		"# TYPE zfs_arcstats_hash_chains gauge",
+		"# TYPE zfs_arcstats_hash_collisions_total counter",
+		"# TYPE zfs_arcstats_cached_only_in_progress gauge",
+		"# TYPE zfs_zfetchstats_io_active gauge",
+		"# TYPE zfs_zfetchstats_io_issued_total counter",
+		`zfs_io_reads_total{pool="tank",dataset=""} 123456`,
+		`zfs_io_written_bytes_total{pool="tank",dataset="tank/home"} 409600000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q", line)
+		}
+	}
+
+	// Not in the fixture, from OpenZFS 2.2
+	for name, gauge := range map[string]bool{
+		"anon_data": true, "mru_metadata": true, "uncached_data": true, "mfu_ghost_metadata": true,
+		"demand_metadata_hits": false, "uncached_hits": false, "scatter_order_3": true,
+		"l2_log_blk_count": true, "l2_data_to_meta_ratio": true, "l2_log_blk_writes": false,
+	} {
+		if isGauge(name) != gauge {
+			t.Errorf("isGauge(%q) = %v, want %v", name, !gauge, gauge)
+		}
+	}
+}
+
+func TestDashboardHelpers(t *testing.T) {
+	if got := bar(50, 75, 100, 8); got != "████░░┃░" {
+		t.Errorf("bar = %q", got)
+	}
+	if got := bar(200, 0, 100, 4); got != "████" {
+		t.Errorf("full bar = %q", got)
+	}
+	if got := stackedBar([]float64{1, 2, 1}, []rune("abc"), 8); got != "aabbbbcc" {
+		t.Errorf("stackedBar = %q", got)
+	}
+	if got := sparkline([]float64{0, 50, 100, -1, 100}, 4); got != "▄█ █" {
+		t.Errorf("sparkline = %q", got)
+	}
+	for _, c := range []struct {
+		d, longer, shorter time.Duration
+	}{
+		{time.Second, 2 * time.Second, 500 * time.Millisecond},
+		{3 * time.Second, 5 * time.Second, 2 * time.Second},
+		{time.Minute, time.Minute, 30 * time.Second},
+		{100 * time.Millisecond, 250 * time.Millisecond, 100 * time.Millisecond},
+	} {
+		if got := nextInterval(c.d, true); got != c.longer {
+			t.Errorf("nextInterval(%v, true) = %v, want %v", c.d, got, c.longer)
+		}
+		if got := nextInterval(c.d, false); got != c.shorter {
+			t.Errorf("nextInterval(%v, false) = %v, want %v", c.d, got, c.shorter)
+		}
+	}
+}
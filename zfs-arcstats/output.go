@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output writes one sample per interval
+type Output interface {
+	Write(s Sample) error
+	Close() error
+}
+
+// redrawOutput keeps rewriting a single line below the header, followed by the I/O lines if any
+type redrawOutput struct {
+	w      io.Writer
+	fields []Field
+	header bool
+	extra  int // I/O lines written last time
+}
+
+func (o *redrawOutput) Write(s Sample) error {
+	if !o.header {
+		o.header = true
+		if _, err := fmt.Fprintln(o.w, formatHeader(o.fields)); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if o.extra > 0 {
+		fmt.Fprintf(&buf, "\x1B[%dA", o.extra)
+	}
+	buf.WriteString("\r\x1B[J" + formatLine(o.fields, s))
+	lines := formatIO(s)
+	for _, line := range lines {
+		buf.WriteString("\n" + line)
+	}
+	o.extra = len(lines)
+	_, err := o.w.Write(buf.Bytes())
+	return err
+}
+
+func (o *redrawOutput) Close() error {
+	_, err := fmt.Fprintln(o.w)
+	return err
+}
+
+// scrollOutput prints one line per sample and repeats the header every HeaderEvery lines
+type scrollOutput struct {
+	w           io.Writer
+	fields      []Field
+	HeaderEvery int // 0 prints the header only once
+	lines       int
+}
+
+func (o *scrollOutput) Write(s Sample) error {
+	if o.lines == 0 || (o.HeaderEvery > 0 && o.lines%o.HeaderEvery == 0) {
+		if _, err := fmt.Fprintln(o.w, formatHeader(o.fields)); err != nil {
+			return err
+		}
+	}
+	o.lines++
+	lines := append([]string{formatLine(o.fields, s)}, formatIO(s)...)
+	_, err := fmt.Fprintln(o.w, strings.Join(lines, "\n"))
+	return err
+}
+
+func (o *scrollOutput) Close() error {
+	return nil
+}
+
+// nopOutput discards samples, e.g. when only pushing to InfluxDB
+type nopOutput struct{}
+
+func (nopOutput) Write(Sample) error { return nil }
+func (nopOutput) Close() error       { return nil }
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// withTime prepends the time field unless fs already has it
+func withTime(fs []Field) []Field {
+	for _, f := range fs {
+		if f.Kind == kindTime {
+			return fs
+		}
+	}
+	return append([]Field{fields["time"]}, fs...)
+}
+
+// metric is a value written by the machine-readable outputs, counters also get a <name>_delta
+type metric struct {
+	name    string
+	counter bool
+	value   func(Stat) uint64
+}
+
+var metrics = []metric{
+	{"hits", true, Stat.Hits},
+	{"misses", true, Stat.Misses},
+	{"demand_hits", true, Stat.DemandHits},
+	{"demand_misses", true, Stat.DemandMisses},
+	{"prefetch_hits", true, Stat.PrefetchHits},
+	{"prefetch_misses", true, Stat.PrefetchMisses},
+	{"metadata_hits", true, metadataHits},
+	{"metadata_misses", true, metadataMisses},
+	{"l2_hits", true, Stat.L2Hits},
+	{"l2_misses", true, Stat.L2Misses},
+	{"size", false, Stat.Size},
+	{"c", false, Stat.Target},
+	{"c_min", false, Stat.TargetMin},
+	{"c_max", false, Stat.TargetMax},
+	{"mru_size", false, Stat.MRUSize},
+	{"mfu_size", false, Stat.MFUSize},
+	{"metadata_size", false, Stat.MetadataSize},
+	{"l2_size", false, Stat.L2Size},
+	{"l2_asize", false, Stat.L2ASize},
+}
+
+// ratios are fractions between 0 and 1 computed over the interval, except the L2ARC compression ratio
+var ratios = []struct {
+	name  string
+	value func(Sample) float64
+}{
+	{"hit_ratio", func(s Sample) float64 { return s.Ratio(Stat.Hits, reads) / 100 }},
+	{"demand_hit_ratio", func(s Sample) float64 { return s.Ratio(Stat.DemandHits, demandReads) / 100 }},
+	{"prefetch_hit_ratio", func(s Sample) float64 { return s.Ratio(Stat.PrefetchHits, prefetchReads) / 100 }},
+	{"metadata_hit_ratio", func(s Sample) float64 { return s.Ratio(metadataHits, metadataReads) / 100 }},
+	{"l2_hit_ratio", func(s Sample) float64 { return s.Ratio(Stat.L2Hits, l2Reads) / 100 }},
+	{"l2_compression", func(s Sample) float64 { return s.Cur.L2Compression() }},
+}
+
+type recordField struct {
+	name  string
+	value any
+}
+
+// record lists the machine-readable values of a sample in a fixed order
+func record(s Sample) []recordField {
+	r := []recordField{
+		{"time", s.Time.Format(time.RFC3339)},
+		{"interval", s.Secs},
+	}
+	for _, m := range metrics {
+		r = append(r, recordField{m.name, m.value(s.Cur)})
+		if m.counter {
+			r = append(r, recordField{m.name + "_delta", s.Delta(m.value)})
+		}
+	}
+	for _, ratio := range ratios {
+		r = append(r, recordField{ratio.name, ratio.value(s)})
+	}
+	return r
+}
+
+type ioRecord struct {
+	IOStat
+	ReadsDelta    uint64 `json:"reads_delta"`
+	WritesDelta   uint64 `json:"writes_delta"`
+	NReadDelta    uint64 `json:"nread_delta"`
+	NWrittenDelta uint64 `json:"nwritten_delta"`
+}
+
+func ioRecords(s Sample) []ioRecord {
+	records := make([]ioRecord, len(s.IO))
+	for i, io := range s.IO {
+		d := s.IODelta(io)
+		records[i] = ioRecord{io, d.Reads, d.Writes, d.NRead, d.NWritten}
+	}
+	return records
+}
+
+// jsonOutput writes one JSON object per line, with an "io" array if I/O statistics are read
+type jsonOutput struct {
+	w io.Writer
+}
+
+func (o *jsonOutput) Write(s Sample) error {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	r := record(s)
+	if s.IO != nil {
+		r = append(r, recordField{"io", ioRecords(s)})
+	}
+	for i, f := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.name)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	_, err := o.w.Write(buf.Bytes())
+	return err
+}
+
+func (o *jsonOutput) Close() error {
+	return nil
+}
+
+// csvOutput writes a header row followed by one row per sample, without I/O statistics
+type csvOutput struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (o *csvOutput) Write(s Sample) error {
+	r := record(s)
+	if !o.header {
+		o.header = true
+		names := make([]string, len(r))
+		for i, f := range r {
+			names[i] = f.name
+		}
+		o.w.Write(names)
+	}
+	row := make([]string, len(r))
+	for i, f := range r {
+		switch v := f.value.(type) {
+		case float64:
+			row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	o.w.Write(row)
+	o.w.Flush()
+	return o.w.Error()
+}
+
+func (o *csvOutput) Close() error {
+	return nil
+}
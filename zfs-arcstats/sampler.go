@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Sampler reads consecutive snapshots of the kstats under Root and pairs them into samples
+type Sampler struct {
+	Root   string
+	ReadIO bool
+
+	last     Stat
+	lastIO   []IOStat
+	lastTime time.Time
+}
+
+// Init reads the first snapshot, the baseline of the first sample
+func (s *Sampler) Init() error {
+	_, _, err := s.Next(time.Now())
+	return err
+}
+
+// Next reads a snapshot taken at now and returns the sample since the previous one.
+// ok is false if there is no valid previous snapshot, e.g. because the ZFS module was
+// reloaded in between, the new snapshot is then the baseline of the next sample.
+func (s *Sampler) Next(now time.Time) (sample Sample, ok bool, err error) {
+	cur, err := GetStats(s.Root)
+	if err != nil {
+		return sample, false, err
+	}
+	sample = Sample{Cur: cur, Prev: s.last, Time: now, Secs: now.Sub(s.lastTime).Seconds()}
+	if s.ReadIO {
+		// Pools and datasets come and go, keep sampling ARC statistics anyway
+		if sample.IO, err = ReadIOStats(s.Root); err != nil {
+			log.Println(err)
+		}
+		if sample.IO == nil {
+			sample.IO = []IOStat{}
+		}
+		sample.PrevIO = ioMap(s.lastIO)
+	}
+	ok = s.last.Kstat != nil
+	if ok && sample.Reset() {
+		log.Println("arcstats were reset, skipping this interval")
+		ok = false
+	}
+	if ok && cur.Snaptime > s.last.Snaptime {
+		// The kstat knows when it was updated better than the ticker does
+		sample.Secs = float64(cur.Snaptime-s.last.Snaptime) / float64(time.Second)
+	}
+	s.last, s.lastIO, s.lastTime = cur, sample.IO, now
+	return sample, ok, nil
+}
+
+// Reset reports whether the kstat was recreated or any counter went backwards between Prev and Cur
+func (s Sample) Reset() bool {
+	if s.Cur.Crtime != s.Prev.Crtime || s.Cur.Snaptime < s.Prev.Snaptime {
+		return true
+	}
+	for _, m := range metrics {
+		if m.counter && m.value(s.Cur) < m.value(s.Prev) {
+			return true
+		}
+	}
+	return false
+}